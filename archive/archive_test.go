@@ -0,0 +1,156 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testTarball(t *testing.T, headers []*tar.Header) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range headers {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(hdr.Name))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(hdr.Name))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUntarCompression(t *testing.T) {
+	tarball := testTarball(t, []*tar.Header{
+		{Name: "etc/", Mode: 0755, Typeflag: tar.TypeDir},
+		{Name: "etc/hostname", Mode: 0644, Typeflag: tar.TypeReg},
+		{Name: "etc/hosts", Linkname: "etc/hostname", Typeflag: tar.TypeLink},
+		{Name: "etc/localtime", Linkname: "/usr/share/zoneinfo/UTC", Mode: 0777, Typeflag: tar.TypeSymlink},
+	})
+
+	var gzipped, zstded bytes.Buffer
+	gw := gzip.NewWriter(&gzipped)
+	gw.Write(tarball)
+	gw.Close()
+	zw, err := zstd.NewWriter(&zstded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(tarball)
+	zw.Close()
+
+	for compression, content := range map[Compression][]byte{
+		Uncompressed: tarball,
+		Gzip:         gzipped.Bytes(),
+		Zstd:         zstded.Bytes(),
+	} {
+		dest, err := ioutil.TempDir("", "mydocker-untar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dest)
+
+		if err := Untar(bytes.NewReader(content), dest); err != nil {
+			t.Fatalf("%s: %v", compression, err)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dest, "etc/hosts"))
+		if err != nil || string(data) != "etc/hostname" {
+			t.Errorf("%s: etc/hosts = %q, %v", compression, data, err)
+		}
+		link, err := os.Readlink(filepath.Join(dest, "etc/localtime"))
+		if err != nil || link != "/usr/share/zoneinfo/UTC" {
+			t.Errorf("%s: etc/localtime -> %q, %v", compression, link, err)
+		}
+	}
+}
+
+func TestUntarStaysInDestination(t *testing.T) {
+	root, err := ioutil.TempDir("", "mydocker-untar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dest := filepath.Join(root, "rootfs")
+	os.Mkdir(dest, 0755)
+
+	escaping := testTarball(t, []*tar.Header{
+		{Name: "../outside", Mode: 0644, Typeflag: tar.TypeReg},
+	})
+	if err := Untar(bytes.NewReader(escaping), dest); err == nil {
+		t.Error("Untar accepted an entry outside the destination")
+	}
+
+	// Symlinks are resolved as if dest were the root directory
+	symlinked := testTarball(t, []*tar.Header{
+		{Name: "abs", Linkname: root, Mode: 0777, Typeflag: tar.TypeSymlink},
+		{Name: "abs/outside", Mode: 0644, Typeflag: tar.TypeReg},
+		{Name: "rel", Linkname: "../..", Mode: 0777, Typeflag: tar.TypeSymlink},
+		{Name: "rel/outside", Mode: 0644, Typeflag: tar.TypeReg},
+	})
+	if err := Untar(bytes.NewReader(symlinked), dest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "outside")); err == nil {
+		t.Error("Untar followed a symlink out of the destination")
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "outside")); err != nil {
+		t.Errorf("rel/outside is not extracted at the root: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, root, "outside")); err != nil {
+		t.Errorf("abs/outside is not extracted inside the root: %v", err)
+	}
+}
+
+func TestTarRoundTrip(t *testing.T) {
+	src, err := ioutil.TempDir("", "mydocker-tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	os.MkdirAll(filepath.Join(src, "data", "sub"), 0750)
+	ioutil.WriteFile(filepath.Join(src, "data", "sub", "run.sh"), []byte("#!/bin/sh"), 0755)
+	os.Link(filepath.Join(src, "data", "sub", "run.sh"), filepath.Join(src, "data", "start.sh"))
+	os.Symlink("sub/run.sh", filepath.Join(src, "data", "link"))
+
+	for name, top := range map[string]string{"copy": "copy", "": "."} {
+		dest, err := ioutil.TempDir("", "mydocker-tar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dest)
+		var buf bytes.Buffer
+		if err := Tar(&buf, filepath.Join(src, "data"), name); err != nil {
+			t.Fatal(err)
+		}
+		if err := Untar(&buf, dest); err != nil {
+			t.Fatal(err)
+		}
+
+		root := filepath.Join(dest, top)
+		if info, err := os.Stat(filepath.Join(root, "sub", "run.sh")); err != nil || info.Mode().Perm() != 0755 {
+			t.Errorf("name %q: run.sh = %v, %v", name, info, err)
+		}
+		if info, err := os.Stat(filepath.Join(root, "sub")); err != nil || info.Mode().Perm() != 0750 {
+			t.Errorf("name %q: sub = %v, %v", name, info, err)
+		}
+		if target, err := os.Readlink(filepath.Join(root, "link")); err != nil || target != "sub/run.sh" {
+			t.Errorf("name %q: link = %q, %v", name, target, err)
+		}
+		a, _ := os.Stat(filepath.Join(root, "sub", "run.sh"))
+		b, _ := os.Stat(filepath.Join(root, "start.sh"))
+		if a == nil || b == nil || !os.SameFile(a, b) {
+			t.Errorf("name %q: hard link not kept", name)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+)
+
+// Compression algorithm of a tarball stream
+type Compression int
+
+const (
+	Uncompressed Compression = iota
+	Gzip
+	Zstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	}
+	return "uncompressed"
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Detect the compression of r by its magic number, and return a reader
+// of the decompressed content
+func DecompressStream(r io.Reader) (io.ReadCloser, Compression, error) {
+	reader := bufio.NewReader(r)
+	magic, _ := reader.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, Gzip, fmt.Errorf("invalid gzip stream: %v", err)
+		}
+		return gzipReader, Gzip, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, Zstd, fmt.Errorf("invalid zstd stream: %v", err)
+		}
+		return &zstdReadCloser{decoder}, Zstd, nil
+	}
+	return ioutil.NopCloser(reader), Uncompressed, nil
+}
+
+// zstd.Decoder.Close does not return an error
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (r *zstdReadCloser) Close() error {
+	r.Decoder.Close()
+	return nil
+}
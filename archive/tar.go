@@ -0,0 +1,78 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Write src, a file or a directory with everything under it, into w as a
+// tarball whose top-level entry is named name. An empty name puts the
+// content of the directory src at the top level. Ownership and permissions
+// are kept, symlinks are archived as they are.
+func Tar(w io.Writer, src, name string) error {
+	tw := tar.NewWriter(w)
+	// Hard links are recorded once, later names link to the first one
+	inodes := map[uint64]string{}
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		entryName := filepath.Join(name, rel)
+		if entryName == "." {
+			return nil
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = entryName
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			hdr.Uid, hdr.Gid = int(stat.Uid), int(stat.Gid)
+			hdr.Uname, hdr.Gname = "", ""
+			if info.Mode().IsRegular() && stat.Nlink > 1 {
+				if first, ok := inodes[stat.Ino]; ok {
+					hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+				} else {
+					inodes[stat.Ino] = hdr.Name
+				}
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, err := io.Copy(tw, file); err != nil {
+			return fmt.Errorf("archive %s: %v", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
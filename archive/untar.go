@@ -0,0 +1,250 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	xattrPAXPrefix = "SCHILY.xattr."
+	maxSymlinks    = 255
+)
+
+// Unpack a tarball, optionally gzip or zstd compressed, into dest.
+// Ownership, permissions, extended attributes, device nodes and hard
+// links are preserved. Every entry is resolved inside dest, so that
+// neither "../" names nor symlinks pointing outside, which are valid
+// inside a container rootfs, can make it write outside dest.
+func Untar(r io.Reader, dest string) error {
+	reader, compression, err := DecompressStream(r)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if dest, err = filepath.Abs(dest); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(reader)
+	var dirs []*tar.Header
+	var entries, size int64
+	start := time.Now()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read %s tarball after %d entries: %v", compression, entries, err)
+		}
+		if err := extractEntry(tr, hdr, dest); err != nil {
+			return fmt.Errorf("extract %s: %v", hdr.Name, err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, hdr)
+		}
+
+		entries++
+		size += hdr.Size
+		if entries%1000 == 0 {
+			log.Infof("Extracted %d entries (%d bytes) into %s", entries, size, dest)
+		}
+	}
+
+	// Creating files in a directory changes its mtime, so directory times
+	// are restored after all entries are extracted, the deepest first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		path, err := entryPath(dest, dirs[i].Name)
+		if err != nil || path == dest {
+			continue
+		}
+		os.Chtimes(path, dirs[i].AccessTime, dirs[i].ModTime)
+	}
+	log.Infof("Extracted %d entries (%d bytes, %s) into %s in %v",
+		entries, size, compression, dest, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// Return the path on host of a tarball entry: its parent directory is
+// resolved inside root, the entry itself is never followed. Names climbing
+// out of root with ".." are rejected.
+func entryPath(root, name string) (string, error) {
+	if clean := filepath.Clean(strings.TrimPrefix(name, "/")); clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("path %s escapes the destination", name)
+	}
+	name = filepath.Clean("/" + name)
+	if name == "/" {
+		return root, nil
+	}
+	parent, err := ResolveInRoot(root, filepath.Dir(name))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, filepath.Base(name)), nil
+}
+
+// Resolve unsafePath as if root were "/". Symlinks are followed, but ".."
+// never climbs above root and absolute symlink targets restart from root.
+func ResolveInRoot(root, unsafePath string) (string, error) {
+	current := "/"
+	remaining := unsafePath
+	for links := 0; remaining != ""; {
+		var part string
+		if i := strings.IndexRune(remaining, '/'); i == -1 {
+			part, remaining = remaining, ""
+		} else {
+			part, remaining = remaining[:i], remaining[i+1:]
+		}
+		if part == "" || part == "." {
+			continue
+		}
+
+		next := filepath.Clean(current + "/" + part)
+		info, err := os.Lstat(filepath.Join(root, next))
+		if err != nil {
+			if os.IsNotExist(err) {
+				current = next
+				continue
+			}
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many symlinks in %s", unsafePath)
+		}
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			current = "/"
+		}
+		remaining = target + "/" + remaining
+	}
+	return filepath.Join(root, current), nil
+}
+
+func extractEntry(tr *tar.Reader, hdr *tar.Header, dest string) error {
+	path, err := entryPath(dest, hdr.Name)
+	if err != nil {
+		return err
+	}
+	if path == dest {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Replace an existing entry, unless both are directories
+	if info, err := os.Lstat(path); err == nil {
+		if !(info.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(path, os.FileMode(mode)); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(mode))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, tr)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	case tar.TypeLink:
+		target, err := entryPath(dest, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := os.Link(target, path); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		// The target is interpreted inside the container, keep it as is
+		if err := os.Symlink(hdr.Linkname, path); err != nil {
+			return err
+		}
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		fileType := map[byte]uint32{
+			tar.TypeChar:  syscall.S_IFCHR,
+			tar.TypeBlock: syscall.S_IFBLK,
+			tar.TypeFifo:  syscall.S_IFIFO,
+		}[hdr.Typeflag]
+		if err := syscall.Mknod(path, fileType|mode, mkdev(hdr.Devmajor, hdr.Devminor)); err != nil {
+			return err
+		}
+	case tar.TypeXGlobalHeader:
+		return nil
+	default:
+		return fmt.Errorf("unsupported entry type %q", hdr.Typeflag)
+	}
+
+	// Only root can give files away
+	if os.Geteuid() == 0 {
+		if err := os.Lchown(path, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+	// Setting attributes would follow a symlink, and a hard link shares
+	// them with its target
+	if hdr.Typeflag == tar.TypeSymlink || hdr.Typeflag == tar.TypeLink {
+		return nil
+	}
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, xattrPAXPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, xattrPAXPrefix)
+		if err := syscall.Setxattr(path, name, []byte(value), 0); err != nil {
+			return fmt.Errorf("set xattr %s: %v", name, err)
+		}
+	}
+	// chown clears setuid and setgid bits, set the mode again
+	if err := os.Chmod(path, tarMode(hdr)); err != nil {
+		return err
+	}
+	return os.Chtimes(path, hdr.AccessTime, hdr.ModTime)
+}
+
+// Convert permission bits of a tar header, including setuid, setgid and
+// sticky bits, into os.FileMode
+func tarMode(hdr *tar.Header) os.FileMode {
+	mode := os.FileMode(hdr.Mode & 0777)
+	if hdr.Mode&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if hdr.Mode&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if hdr.Mode&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// Encode a device number the same way as glibc makedev(3)
+func mkdev(major, minor int64) int {
+	return int((minor & 0xff) | ((major & 0xfff) << 8) |
+		((minor &^ 0xff) << 12) | ((major &^ 0xfff) << 32))
+}
@@ -0,0 +1,19 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Sum the size of regular files under root, like `du -s`. Files which
+// cannot be read are skipped.
+func DiskUsage(root string) int64 {
+	var size int64
+	filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
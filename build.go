@@ -0,0 +1,92 @@
+package main
+
+import (
+	"./build"
+	"./cgroups"
+	"./cgroups/subsystems"
+	"./container"
+	"./image"
+	"./network"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"syscall"
+)
+
+func buildImage(options *build.Options, resource *subsystems.ResourceConfig, networkName string) error {
+	options.Runner = buildRunner(resource, networkName)
+	id, err := build.Build(options)
+	if err != nil {
+		return err
+	}
+	fmt.Println(id)
+	return nil
+}
+
+// Return a runner executing RUN steps in containers limited by resource
+// and connected to networkName if not empty. Output of the step goes to
+// the terminal, the container is not listed by `ps`.
+func buildRunner(resource *subsystems.ResourceConfig, networkName string) build.Runner {
+	return func(imageId string, imageConfig *image.ImageConfig, cmd []string) (string, func(), error) {
+		id := randStringBytes(10)
+		config := &container.ContainerConfig{
+			TTY:           true,
+			Name:          "build-" + id,
+			ID:            id,
+			ImageName:     imageId,
+			Env:           imageConfig.Env,
+			CmdArray:      cmd,
+			WorkingDir:    imageConfig.WorkingDir,
+			User:          imageConfig.User,
+			NetworkName:   networkName,
+			StorageDriver: container.DefaultStorageDriver,
+			// Intermediate images are not signed, FROM checked the base image
+			SkipVerify: true,
+			Resource:   resource,
+		}
+		driver, err := container.GetStorageDriver(config.StorageDriver)
+		if err != nil {
+			return "", nil, err
+		}
+
+		// The workspace may be partly set up even if the process is not
+		cleanup := func() {
+			container.DeleteWorkSpace(nil, config.Name, config.StorageDriver)
+		}
+		containerProcess, writePipe := container.NewParentProcess(config)
+		if containerProcess == nil {
+			return "", cleanup, fmt.Errorf("Create build container error")
+		}
+		// Build steps do not read from the terminal
+		containerProcess.Stdin = nil
+		if err := containerProcess.Start(); err != nil {
+			writePipe.Close()
+			return "", cleanup, err
+		}
+
+		cgroupManager := cgroups.NewCgroupManager(config.ID)
+		defer cgroupManager.Destroy()
+		cgroupManager.Set(config.Resource)
+		cgroupManager.Apply(containerProcess.Process.Pid)
+
+		if networkName != "" {
+			network.LoadExistNetwork()
+			containerInfo := makeContainerInfo(containerProcess.Process.Pid, config)
+			if err := network.Connect(networkName, containerInfo); err != nil {
+				writePipe.Close()
+				containerProcess.Process.Kill()
+				containerProcess.Wait()
+				return "", cleanup, err
+			}
+		}
+
+		sendInitCommand(config, writePipe)
+		err = containerProcess.Wait()
+		syscall.Mount("proc", "/proc", "proc",
+			uintptr(syscall.MS_NOEXEC|syscall.MS_NOSUID|syscall.MS_NODEV), "")
+		log.Infof("$ mount proc proc /proc")
+		if err != nil {
+			return "", cleanup, fmt.Errorf("%q returned %v", cmd, err)
+		}
+		return driver.DiffPath(config.Name), cleanup, nil
+	}
+}
@@ -0,0 +1,224 @@
+package build
+
+import (
+	"../image"
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	buildfile := `# comment
+FROM busybox AS base
+ENV PATH=/usr/bin:/bin GREETING="hello world"
+env LEGACY some value
+RUN echo "$GREETING" && \
+    touch /done
+CMD ["sh", "-c", "echo hi"]
+COPY a.txt "b c.txt" /dst/
+`
+	instructions, err := Parse(strings.NewReader(buildfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		command string
+		args    []string
+		json    bool
+		line    int
+	}{
+		{"FROM", []string{"busybox", "AS", "base"}, false, 2},
+		{"ENV", []string{"PATH", "/usr/bin:/bin", "GREETING", "hello world"}, false, 3},
+		{"ENV", []string{"LEGACY", "some value"}, false, 4},
+		{"RUN", []string{`echo "$GREETING" &&     touch /done`}, false, 5},
+		{"CMD", []string{"sh", "-c", "echo hi"}, true, 7},
+		{"COPY", []string{"a.txt", "b c.txt", "/dst/"}, false, 8},
+	}
+	if len(instructions) != len(expected) {
+		t.Fatalf("parsed %d instructions", len(instructions))
+	}
+	for i, e := range expected {
+		instruction := instructions[i]
+		if instruction.Command != e.command || !reflect.DeepEqual(instruction.Args, e.args) ||
+			instruction.JSON != e.json || instruction.Line != e.line {
+			t.Errorf("instruction %d = %+v", i, instruction)
+		}
+	}
+
+	for _, invalid := range []string{"FROM busybox\nFETCH x", "FROM busybox\nRUN", "LABEL a", "FROM a \\"} {
+		if _, err := Parse(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Parse(%q) succeeded", invalid)
+		}
+	}
+}
+
+// Create the image "base" in a store under root
+func createBaseImage(t *testing.T, root string) {
+	image.StoreUrl = filepath.Join(root, "store")
+
+	var base bytes.Buffer
+	tw := tar.NewWriter(&base)
+	tw.WriteHeader(&tar.Header{Name: "bin/", Mode: 0755, Typeflag: tar.TypeDir})
+	tw.Close()
+	layer, err := image.WriteLayer(&base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := image.CreateImage("base", image.NewImage(), []*image.Layer{layer}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Read the names of the entries of a layer
+func layerEntries(t *testing.T, digest string) []string {
+	layerTar, err := image.OpenLayerTar(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer layerTar.Close()
+	tr := tar.NewReader(layerTar)
+	var names []string
+	for hdr, err := tr.Next(); err == nil; hdr, err = tr.Next() {
+		names = append(names, hdr.Name)
+	}
+	return names
+}
+
+func TestBuild(t *testing.T) {
+	root, err := ioutil.TempDir("", "mydocker-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	createBaseImage(t, root)
+
+	contextDir := filepath.Join(root, "context")
+	os.MkdirAll(filepath.Join(contextDir, "conf"), 0755)
+	ioutil.WriteFile(filepath.Join(contextDir, "conf", "app.conf"), []byte("port=80"), 0644)
+	ioutil.WriteFile(filepath.Join(contextDir, "Buildfile"), []byte(`FROM base
+ENV APP=/app
+WORKDIR $APP
+COPY conf ./conf/
+RUN make
+USER nobody
+EXPOSE 80
+LABEL version=1.0
+STOPSIGNAL SIGINT
+ENTRYPOINT ["/app/run"]
+`), 0644)
+
+	runs := 0
+	runner := func(imageId string, config *image.ImageConfig, cmd []string) (string, func(), error) {
+		runs++
+		if config.WorkingDir != "/app" || !reflect.DeepEqual(cmd, []string{"/bin/sh", "-c", "make"}) {
+			t.Errorf("run %v in %s", cmd, config.WorkingDir)
+		}
+		diffDir, _ := ioutil.TempDir(root, "diff")
+		ioutil.WriteFile(filepath.Join(diffDir, "built"), nil, 0644)
+		return diffDir, func() { os.RemoveAll(diffDir) }, nil
+	}
+	options := &Options{
+		Buildfile:  filepath.Join(contextDir, "Buildfile"),
+		ContextDir: contextDir,
+		Tag:        "app:1.0",
+		Runner:     runner,
+	}
+
+	id, err := Build(options)
+	if err != nil {
+		t.Fatalf("build %v", err)
+	}
+	_, manifest, img, err := image.GetImage("app:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Layers) != 3 {
+		t.Errorf("built %d layers", len(manifest.Layers))
+	}
+	config := img.Config
+	if config.User != "nobody" || config.StopSignal != "SIGINT" || config.WorkingDir != "/app" || config.Labels["version"] != "1.0" ||
+		!reflect.DeepEqual(config.Entrypoint, []string{"/app/run"}) || !reflect.DeepEqual(config.Env, []string{"APP=/app"}) {
+		t.Errorf("unexpected config %+v", config)
+	}
+	if _, ok := config.ExposedPorts["80/tcp"]; !ok {
+		t.Errorf("unexpected exposed ports %v", config.ExposedPorts)
+	}
+
+	// Every step is in the history, the base layer has none recorded
+	history, err := image.GetHistory("app:1.0")
+	if err != nil || len(history) != 10 {
+		t.Fatalf("history has %d steps, %v", len(history), err)
+	}
+	if history[0].Id != id || history[0].CreatedBy != `ENTRYPOINT ["/app/run"]` || !history[0].EmptyLayer {
+		t.Errorf("unexpected last step %+v", history[0])
+	}
+	if history[5].CreatedBy != "RUN make" || history[5].Layer != manifest.Layers[2].Digest {
+		t.Errorf("unexpected RUN step %+v", history[5])
+	}
+	if history[9].CreatedBy != "" || history[9].Layer != manifest.Layers[0].Digest {
+		t.Errorf("unexpected base step %+v", history[9])
+	}
+
+	// COPY layer holds the copied file under the working dir
+	if names := layerEntries(t, manifest.Layers[1].Digest); !reflect.DeepEqual(names, []string{"app/conf/app.conf"}) {
+		t.Errorf("COPY layer holds %v", names)
+	}
+
+	// Unchanged steps are cached
+	if rebuilt, err := Build(options); err != nil || rebuilt != id || runs != 1 {
+		t.Errorf("rebuild = %s, %v after %d runs, expected %s", rebuilt, err, runs, id)
+	}
+	// A changed input invalidates the step and the following ones
+	ioutil.WriteFile(filepath.Join(contextDir, "conf", "app.conf"), []byte("port=8080"), 0644)
+	if rebuilt, err := Build(options); err != nil || rebuilt == id || runs != 2 {
+		t.Errorf("rebuild = %s, %v after %d runs", rebuilt, err, runs)
+	}
+
+	infos, err := image.ListImages(false)
+	if err != nil || len(infos) != 2 {
+		t.Errorf("intermediate images are listed: %d, %v", len(infos), err)
+	}
+}
+
+func TestBuildAddArchive(t *testing.T) {
+	root, err := ioutil.TempDir("", "mydocker-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	createBaseImage(t, root)
+
+	contextDir := filepath.Join(root, "context")
+	os.MkdirAll(contextDir, 0755)
+	var site bytes.Buffer
+	tw := tar.NewWriter(&site)
+	tw.WriteHeader(&tar.Header{Name: "index.html", Mode: 0644, Size: 2})
+	tw.Write([]byte("hi"))
+	tw.Close()
+	ioutil.WriteFile(filepath.Join(contextDir, "site.tar"), site.Bytes(), 0644)
+	ioutil.WriteFile(filepath.Join(contextDir, "robots.txt"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(contextDir, "Buildfile"), []byte("FROM base\nADD site.tar robots.txt /srv/\n"), 0644)
+
+	options := &Options{
+		Buildfile:  filepath.Join(contextDir, "Buildfile"),
+		ContextDir: contextDir,
+		Tag:        "site",
+	}
+	if _, err := Build(options); err != nil {
+		t.Fatalf("build %v", err)
+	}
+	_, manifest, _, err := image.GetImage("site")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The archive is extracted and the following source copied as well
+	names := layerEntries(t, manifest.Layers[1].Digest)
+	if !reflect.DeepEqual(names, []string{"srv/index.html", "srv/robots.txt"}) {
+		t.Errorf("ADD layer holds %v", names)
+	}
+}
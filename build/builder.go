@@ -0,0 +1,293 @@
+package build
+
+import (
+	"../container"
+	"../image"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"strings"
+)
+
+// Runner executes cmd in a container created from an image, with the
+// environment, working dir and user of config. It returns the write layer
+// of the container holding the changes, and a function removing the
+// container once the layer has been archived.
+type Runner func(imageId string, config *image.ImageConfig, cmd []string) (string, func(), error)
+
+type Options struct {
+	Buildfile  string              // Path of the Buildfile
+	ContextDir string              // Directory COPY and ADD read from
+	Tag        string              // Reference of the built image, may be empty
+	NoCache    bool                // Execute every step, even if cached
+	SkipVerify bool                // Skip the signature policy check of base images
+	Runner     Runner              // Executes RUN steps
+	Auth       *image.RegistryAuth // Credentials to pull base images
+}
+
+// State of a build, it is the image produced by the last step
+type builder struct {
+	options *Options
+	imageId string
+	img     *image.Image
+	layers  []*image.Layer
+}
+
+// Build an image from the Buildfile and return its ID. Every step produces
+// an image on top of the previous one, RUN, COPY and ADD add a layer while
+// other instructions only change the config.
+func Build(options *Options) (string, error) {
+	file, err := os.Open(options.Buildfile)
+	if err != nil {
+		return "", err
+	}
+	instructions, err := Parse(file)
+	file.Close()
+	if err != nil {
+		return "", fmt.Errorf("Parse %s error: %v", options.Buildfile, err)
+	}
+	if len(instructions) == 0 || instructions[0].Command != "FROM" {
+		return "", fmt.Errorf("%s must start with a FROM instruction", options.Buildfile)
+	}
+
+	b := &builder{options: options}
+	for i, instruction := range instructions {
+		log.Infof("Step %d/%d : %s", i+1, len(instructions), instruction.Original)
+		if err := b.dispatch(instruction); err != nil {
+			return "", fmt.Errorf("Step %d/%d (line %d) %s: %v",
+				i+1, len(instructions), instruction.Line, instruction.Command, err)
+		}
+		log.Infof(" ---> %s", image.ShortID(b.imageId))
+	}
+
+	if b.imageId == "" {
+		return "", fmt.Errorf("%s builds an empty image", options.Buildfile)
+	}
+	if options.Tag != "" {
+		if err := image.Tag(b.imageId, options.Tag); err != nil {
+			return "", err
+		}
+	}
+	log.Infof("Successfully built %s", image.ShortID(b.imageId))
+	return b.imageId, nil
+}
+
+func (b *builder) dispatch(instruction *Instruction) error {
+	switch instruction.Command {
+	case "FROM":
+		return b.from(instruction)
+	case "RUN":
+		return b.run(instruction)
+	case "COPY":
+		return b.copy(instruction, false)
+	case "ADD":
+		return b.copy(instruction, true)
+	}
+
+	key := b.cacheKey(instruction, "")
+	if b.useCache(key) {
+		return nil
+	}
+	config := &b.img.Config
+	args := b.expandAll(instruction.Args)
+	switch instruction.Command {
+	case "ENV":
+		for i := 0; i+1 < len(args); i += 2 {
+			config.Env = setEnv(config.Env, args[i], args[i+1])
+		}
+	case "LABEL":
+		if config.Labels == nil {
+			config.Labels = map[string]string{}
+		}
+		for i := 0; i+1 < len(args); i += 2 {
+			config.Labels[args[i]] = args[i+1]
+		}
+	case "WORKDIR":
+		config.WorkingDir = path.Join("/", config.WorkingDir, args[0])
+		if path.IsAbs(args[0]) {
+			config.WorkingDir = path.Clean(args[0])
+		}
+	case "USER":
+		config.User = args[0]
+	case "STOPSIGNAL":
+		config.StopSignal = args[0]
+	case "EXPOSE":
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = map[string]struct{}{}
+		}
+		for _, port := range args {
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			config.ExposedPorts[port] = struct{}{}
+		}
+	case "CMD":
+		config.Cmd = commandArgs(instruction)
+	case "ENTRYPOINT":
+		config.Entrypoint = commandArgs(instruction)
+		// The command of the base image was meant for its entrypoint
+		config.Cmd = nil
+	}
+	return b.commit(key, instruction, nil)
+}
+
+// Start from a base image, which is pulled if it is not in the store.
+// "scratch" starts from an empty image.
+func (b *builder) from(instruction *Instruction) error {
+	args := b.expandAll(instruction.Args)
+	if len(args) != 1 && !(len(args) == 3 && strings.ToUpper(args[1]) == "AS") {
+		return fmt.Errorf("expects an image, optionally followed by AS name")
+	}
+	ref := args[0]
+	if ref == "scratch" {
+		b.imageId, b.img, b.layers = "", image.NewImage(), nil
+		return nil
+	}
+
+	if err := container.EnsureImage(ref); err != nil {
+		log.Infof("Image %s not found, pull it", ref)
+		if _, err := image.Pull(ref, b.options.Auth); err != nil {
+			return err
+		}
+	}
+	if !b.options.SkipVerify {
+		if err := image.VerifyImage(ref); err != nil {
+			return err
+		}
+	}
+	id, err := image.Resolve(ref)
+	if err != nil {
+		return err
+	}
+	return b.setImage(id)
+}
+
+func (b *builder) run(instruction *Instruction) error {
+	key := b.cacheKey(instruction, "")
+	if b.useCache(key) {
+		return nil
+	}
+	if b.imageId == "" {
+		return fmt.Errorf("cannot run commands in an empty image")
+	}
+
+	diffDir, cleanup, err := b.options.Runner(b.imageId, &b.img.Config, commandArgs(instruction))
+	if cleanup != nil {
+		defer cleanup()
+	}
+	if err != nil {
+		return err
+	}
+	layer, err := image.WriteLayerDir(diffDir, nil)
+	if err != nil {
+		return err
+	}
+	return b.commit(key, instruction, layer)
+}
+
+// Record the current config and layers, plus layer if not nil, as a new
+// image under the cache key. The instruction is kept in the history.
+func (b *builder) commit(key string, instruction *Instruction, layer *image.Layer) error {
+	layers := b.layers
+	if layer != nil {
+		layers = append(layers, layer)
+	}
+	img := b.img
+	img.AddHistory(instruction.Original, "", layer == nil)
+	id, err := image.CreateImage("", img, layers)
+	if err != nil {
+		return err
+	}
+	if err := image.RecordBuildCache(key, id); err != nil {
+		return err
+	}
+	return b.setImage(id)
+}
+
+// Reuse the image cached under key, unless caching is disabled
+func (b *builder) useCache(key string) bool {
+	if b.options.NoCache {
+		return false
+	}
+	id, ok := image.LookupBuildCache(key)
+	if !ok {
+		return false
+	}
+	if err := b.setImage(id); err != nil {
+		log.Warnf("Use cached image %s error %v", id, err)
+		return false
+	}
+	log.Infof(" ---> Using cache")
+	return true
+}
+
+func (b *builder) setImage(id string) error {
+	_, manifest, img, err := image.GetImage(id)
+	if err != nil {
+		return err
+	}
+	layers, err := image.ImageLayers(manifest, img)
+	if err != nil {
+		return err
+	}
+	b.imageId, b.img, b.layers = id, img, layers
+	return nil
+}
+
+// A step is identified by the image it starts from, the instruction and a
+// digest of the files it reads
+func (b *builder) cacheKey(instruction *Instruction, inputs string) string {
+	parent := b.imageId
+	if parent == "" {
+		parent = "scratch"
+	}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n%s", parent, instruction.Original, inputs)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Expand $VAR and ${VAR} with the environment of the image
+func (b *builder) expand(text string) string {
+	return os.Expand(text, func(name string) string {
+		for _, env := range b.img.Config.Env {
+			if strings.HasPrefix(env, name+"=") {
+				return strings.TrimPrefix(env, name+"=")
+			}
+		}
+		return ""
+	})
+}
+
+func (b *builder) expandAll(args []string) []string {
+	if b.img == nil {
+		return args
+	}
+	var expanded []string
+	for _, arg := range args {
+		expanded = append(expanded, b.expand(arg))
+	}
+	return expanded
+}
+
+// Return the command of RUN, CMD or ENTRYPOINT, the shell form runs in
+// "/bin/sh -c"
+func commandArgs(instruction *Instruction) []string {
+	if instruction.JSON {
+		return instruction.Args
+	}
+	return []string{"/bin/sh", "-c", instruction.Args[0]}
+}
+
+// Set key to value in a "KEY=value" list, replacing a previous value
+func setEnv(env []string, key, value string) []string {
+	for i, kv := range env {
+		if strings.HasPrefix(kv, key+"=") {
+			env[i] = key + "=" + value
+			return env
+		}
+	}
+	return append(env, key+"="+value)
+}
@@ -0,0 +1,267 @@
+package build
+
+import (
+	"../archive"
+	"../image"
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Files copied into the image are produced twice: first into a hash, which
+// identifies the inputs of the step in the cache key, then, on a cache
+// miss, into the tarball of the new layer.
+type entrySink interface {
+	writeEntry(hdr *tar.Header, content io.Reader) error
+}
+
+type tarSink struct {
+	tw *tar.Writer
+}
+
+func (s *tarSink) writeEntry(hdr *tar.Header, content io.Reader) error {
+	if err := s.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if content != nil {
+		if _, err := io.Copy(s.tw, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Modification times are left out, so that a fresh checkout of the same
+// files hits the cache
+type hashSink struct {
+	hash hash.Hash
+}
+
+func (s *hashSink) writeEntry(hdr *tar.Header, content io.Reader) error {
+	fmt.Fprintf(s.hash, "%s %o %c %s %d %d %d\n",
+		hdr.Name, hdr.Mode, hdr.Typeflag, hdr.Linkname, hdr.Uid, hdr.Gid, hdr.Size)
+	if content != nil {
+		if _, err := io.Copy(s.hash, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type copier struct {
+	sources   []string
+	dest      string
+	destIsDir bool
+	extract   bool
+}
+
+// COPY <src>... <dest> copies files and directories of the build context
+// into the image. ADD also unpacks local tarballs into dest.
+func (b *builder) copy(instruction *Instruction, extract bool) error {
+	args := b.expandAll(instruction.Args)
+	if len(args) < 2 {
+		return fmt.Errorf("expects at least one source and a destination")
+	}
+	if !instruction.JSON && strings.HasPrefix(args[0], "--") {
+		return fmt.Errorf("unsupported flag %s", args[0])
+	}
+	sources, err := contextSources(b.options.ContextDir, args[:len(args)-1], extract)
+	if err != nil {
+		return err
+	}
+	dest := args[len(args)-1]
+	c := &copier{
+		sources:   sources,
+		destIsDir: strings.HasSuffix(dest, "/") || len(sources) > 1,
+		extract:   extract,
+	}
+	c.dest = path.Join("/", b.img.Config.WorkingDir, dest)
+	if path.IsAbs(dest) {
+		c.dest = path.Clean(dest)
+	}
+
+	inputs := sha256.New()
+	if err := c.copyTo(&hashSink{inputs}); err != nil {
+		return err
+	}
+	key := b.cacheKey(instruction, hex.EncodeToString(inputs.Sum(nil)))
+	if b.useCache(key) {
+		return nil
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(writer)
+		err := c.copyTo(&tarSink{tw})
+		if err == nil {
+			err = tw.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	layer, err := image.WriteLayer(reader)
+	reader.Close()
+	if err != nil {
+		return err
+	}
+	return b.commit(key, instruction, layer)
+}
+
+// Return paths of the build context matching the source patterns. Sources
+// must stay inside the context, even through symlinks.
+func contextSources(contextDir string, patterns []string, extract bool) ([]string, error) {
+	root, err := filepath.EvalSymlinks(contextDir)
+	if err != nil {
+		return nil, err
+	}
+	var sources []string
+	for _, pattern := range patterns {
+		if extract && (strings.HasPrefix(pattern, "http://") || strings.HasPrefix(pattern, "https://")) {
+			return nil, fmt.Errorf("ADD from URL is not supported, download %s in a RUN step", pattern)
+		}
+		matches, err := filepath.Glob(filepath.Join(root, filepath.Clean("/"+pattern)))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: no such file in build context", pattern)
+		}
+		for _, match := range matches {
+			real, err := filepath.EvalSymlinks(match)
+			if err != nil {
+				return nil, err
+			}
+			if rel, err := filepath.Rel(root, real); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+				return nil, fmt.Errorf("%s is outside of the build context", pattern)
+			}
+			sources = append(sources, real)
+		}
+	}
+	return sources, nil
+}
+
+func (c *copier) copyTo(sink entrySink) error {
+	for _, source := range c.sources {
+		info, err := os.Stat(source)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// The content of a directory is copied, not the directory itself
+			err = filepath.Walk(source, func(file string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				rel, err := filepath.Rel(source, file)
+				if err != nil || rel == "." {
+					return err
+				}
+				return writeFile(sink, file, info, path.Join(c.dest, filepath.ToSlash(rel)))
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if c.extract {
+			extracted, err := extractArchive(sink, source, c.dest)
+			if err != nil {
+				return err
+			}
+			if extracted {
+				continue
+			}
+		}
+		target := c.dest
+		if c.destIsDir {
+			target = path.Join(c.dest, filepath.Base(source))
+		}
+		if err := writeFile(sink, source, info, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copy a file of the build context to name in the image, owned by root
+func writeFile(sink entrySink, file string, info os.FileInfo, name string) error {
+	link := ""
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(file)
+		if err != nil {
+			return err
+		}
+		link = target
+	case info.IsDir(), info.Mode().IsRegular():
+	default:
+		// Sockets and devices do not belong in an image
+		return nil
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = strings.TrimPrefix(name, "/")
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	if !info.Mode().IsRegular() {
+		return sink.writeEntry(hdr, nil)
+	}
+
+	content, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	return sink.writeEntry(hdr, content)
+}
+
+// Unpack file into dest if it is a tarball, possibly compressed. It
+// returns false if file is not a tarball.
+func extractArchive(sink entrySink, file, dest string) (bool, error) {
+	content, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer content.Close()
+	reader, _, err := archive.DecompressStream(content)
+	if err != nil {
+		return false, nil
+	}
+	defer reader.Close()
+
+	tr := tar.NewReader(reader)
+	for entries := 0; ; entries++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries > 0, nil
+		}
+		if err != nil {
+			if entries == 0 {
+				return false, nil
+			}
+			return true, fmt.Errorf("Unpack %s error: %v", filepath.Base(file), err)
+		}
+		hdr.Name = strings.TrimPrefix(path.Join(dest, path.Clean("/"+hdr.Name)), "/")
+		if hdr.Typeflag == tar.TypeDir {
+			hdr.Name += "/"
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = strings.TrimPrefix(path.Join(dest, path.Clean("/"+hdr.Linkname)), "/")
+		}
+		if err := sink.writeEntry(hdr, tr); err != nil {
+			return true, err
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package build
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A Buildfile lists instructions executed in order to build an image,
+// with the same syntax as a Dockerfile:
+//
+// # comment
+// FROM busybox
+// ENV GREETING="hello world"
+// COPY hello.sh /usr/local/bin/
+// RUN chmod +x /usr/local/bin/hello.sh && mkdir /data
+// CMD ["hello.sh"]
+//
+// Instructions are case insensitive, a trailing backslash continues an
+// instruction on the next line.
+type Instruction struct {
+	Command  string   // Upper case instruction, for example RUN
+	Args     []string // Arguments, the elements of the array in JSON form
+	JSON     bool     // Whether arguments are written as a JSON array
+	Original string   // Instruction as written, without line continuations
+	Line     int      // Line number of the instruction
+}
+
+// Instructions accepting the JSON form ["executable", "param"]
+var jsonForm = map[string]bool{
+	"RUN":        true,
+	"CMD":        true,
+	"ENTRYPOINT": true,
+	"COPY":       true,
+	"ADD":        true,
+}
+
+var commands = map[string]bool{
+	"FROM":       true,
+	"RUN":        true,
+	"COPY":       true,
+	"ADD":        true,
+	"ENV":        true,
+	"WORKDIR":    true,
+	"CMD":        true,
+	"ENTRYPOINT": true,
+	"USER":       true,
+	"EXPOSE":     true,
+	"LABEL":      true,
+	"STOPSIGNAL": true,
+}
+
+func Parse(r io.Reader) ([]*Instruction, error) {
+	var instructions []*Instruction
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo, start := 0, 0
+	current := ""
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if current == "" {
+			start = lineNo
+		}
+		if strings.HasSuffix(line, "\\") {
+			current += strings.TrimSuffix(line, "\\")
+			continue
+		}
+		current += line
+
+		instruction, err := parseInstruction(current, start)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+		current = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current != "" {
+		return nil, fmt.Errorf("Line %d: unterminated line continuation", start)
+	}
+	return instructions, nil
+}
+
+func parseInstruction(text string, line int) (*Instruction, error) {
+	text = strings.TrimSpace(text)
+	command, rest := text, ""
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		command, rest = text[:i], strings.TrimSpace(text[i+1:])
+	}
+	instruction := &Instruction{
+		Command:  strings.ToUpper(command),
+		Original: text,
+		Line:     line,
+	}
+	if !commands[instruction.Command] {
+		return nil, fmt.Errorf("Line %d: unknown instruction %s", line, command)
+	}
+	if rest == "" {
+		return nil, fmt.Errorf("Line %d: %s requires arguments", line, instruction.Command)
+	}
+
+	if jsonForm[instruction.Command] && strings.HasPrefix(rest, "[") {
+		if err := json.Unmarshal([]byte(rest), &instruction.Args); err == nil {
+			instruction.JSON = true
+			return instruction, nil
+		}
+	}
+	switch instruction.Command {
+	case "RUN", "CMD", "ENTRYPOINT":
+		instruction.Args = []string{rest}
+	case "WORKDIR", "USER", "STOPSIGNAL":
+		instruction.Args = []string{rest}
+	case "ENV", "LABEL":
+		args, err := parsePairs(instruction.Command, rest)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", line, err)
+		}
+		instruction.Args = args
+	default:
+		args, err := splitWords(rest)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", line, err)
+		}
+		instruction.Args = args
+	}
+	return instruction, nil
+}
+
+// Parse "key=value ..." pairs into alternating keys and values. ENV also
+// accepts the legacy form "key value" setting a single variable.
+func parsePairs(command, text string) ([]string, error) {
+	words, err := splitWords(text)
+	if err != nil {
+		return nil, err
+	}
+	if command == "ENV" && !strings.Contains(words[0], "=") {
+		key := words[0]
+		value := strings.TrimSpace(strings.TrimPrefix(text, key))
+		return []string{key, value}, nil
+	}
+	var pairs []string
+	for _, word := range words {
+		i := strings.Index(word, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s expects key=value, got %q", command, word)
+		}
+		pairs = append(pairs, word[:i], word[i+1:])
+	}
+	return pairs, nil
+}
+
+// Split text into words separated by spaces. Quotes group words and are
+// removed, a backslash escapes the next character outside single quotes.
+func splitWords(text string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, c := range text {
+		switch {
+		case escaped:
+			word.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote, inWord = c, true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", text)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
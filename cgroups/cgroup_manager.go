@@ -34,6 +34,16 @@ func (c *CgroupManager) Set(res *subsystems.ResourceConfig) error {
 	return nil
 }
 
+// Suspend all processes in the cgroup
+func (c *CgroupManager) Pause() error {
+	return subsystems.Freezer.Freeze(c.Path, subsystems.FROZEN)
+}
+
+// Resume processes suspended by Pause
+func (c *CgroupManager) Resume() error {
+	return subsystems.Freezer.Freeze(c.Path, subsystems.THAWED)
+}
+
 // Release cgroup
 func (c *CgroupManager) Destroy() error {
 	for _, subSysIns := range subsystems.SubsystemsIns {
@@ -0,0 +1,80 @@
+package subsystems
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The freezer subsystem suspends and resumes all tasks in a cgroup. It is
+// used to pause a container, for example while its filesystem is committed.
+type FreezerSubSystem struct {
+}
+
+const (
+	FROZEN = "FROZEN"
+	THAWED = "THAWED"
+)
+
+func (s *FreezerSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	// No resource to limit, just make sure the cgroup exists
+	_, err := GetCgroupPath(s.Name(), cgroupPath, true)
+	return err
+}
+
+func (s *FreezerSubSystem) Remove(cgroupPath string) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		log.Infof("$ rm -rf %s", subsysCgroupPath)
+		return os.RemoveAll(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+func (s *FreezerSubSystem) Apply(cgroupPath string, pid int) error {
+	if subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		} else {
+			log.Infof("$ echo %d > %s", pid, subsysCgroupPath+"/tasks")
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+}
+
+func (s *FreezerSubSystem) Name() string {
+	return "freezer"
+}
+
+// Change the freezer state of a cgroup to FROZEN or THAWED, and wait
+// until all tasks have reached that state
+func (s *FreezerSubSystem) Freeze(cgroupPath string, state string) error {
+	subsysCgroupPath, err := GetCgroupPath(s.Name(), cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+	stateFile := path.Join(subsysCgroupPath, "freezer.state")
+	if err := ioutil.WriteFile(stateFile, []byte(state), 0644); err != nil {
+		return fmt.Errorf("set cgroup freezer state fail %v", err)
+	}
+	log.Infof("$ echo %s > %s", state, stateFile)
+
+	for i := 0; i < 100; i++ {
+		current, err := ioutil.ReadFile(stateFile)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(current)) == state {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("cgroup %s did not reach freezer state %s", cgroupPath, state)
+}
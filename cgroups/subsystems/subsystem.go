@@ -21,13 +21,16 @@ type Subsystem interface {
 	Remove(path string) error
 }
 
-// A subsystem array, each entry contains three pointers,
-// pointing to CpusetSubSystem, MemorySubSystem and
-// CpuSubSystem, respectively.
+// A subsystem array, each entry contains four pointers,
+// pointing to CpusetSubSystem, MemorySubSystem, CpuSubSystem
+// and FreezerSubSystem, respectively.
 var (
+	Freezer = &FreezerSubSystem{}
+
 	SubsystemsIns = []Subsystem{
 		&CpusetSubSystem{},
 		&MemorySubSystem{},
 		&CpuSubSystem{},
+		Freezer,
 	}
 )
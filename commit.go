@@ -1,19 +1,57 @@
 package main
 
 import (
+	"./cgroups"
+	"./container"
+	"./image"
+	"fmt"
 	log "github.com/sirupsen/logrus"
-	"os/exec"
 )
 
-func commitContainer(imageName string) {
-	mntURL := "/root/mnt"
-	imageTar := "/root/" + imageName + ".tar"
-	// fmt.Printf("%s", imageTar)
-	log.Infof("$ tar -czf %s -C %s .", imageTar, mntURL)
-	if _, err := exec.Command("tar", "-czf", imageTar, "-C", mntURL, ".").CombinedOutput(); err != nil {
-		log.Errorf("Tar folder %s error %v", mntURL, err)
-	} else {
-		log.Infof("$ tar -czf %s -C %s .", imageTar, mntURL)
-		log.Infof("Package image: %s", imageTar)
+// Commit the write layer of a container as a new layer on top of the
+// image the container was created from
+func commitContainer(containerName, imageName string, options *image.CommitOptions, pause bool) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("Get container %s info error %v", containerName, err)
 	}
+	driver, err := container.GetStorageDriver(containerInfo.StorageDriver)
+	if err != nil {
+		return err
+	}
+	if containerInfo.Rootfs != "" {
+		return fmt.Errorf("Container %s runs from rootfs %s, export it and import the tarball instead",
+			containerName, containerInfo.Rootfs)
+	}
+	if containerInfo.ReadOnly {
+		return fmt.Errorf("Container %s is read-only, it has no changes to commit", containerName)
+	}
+	parent := containerImageID(containerInfo.ImageId, containerInfo.ImageName)
+	if parent == "" {
+		return fmt.Errorf("Image %s of container %s not found", containerInfo.ImageName, containerName)
+	}
+
+	// Freeze processes, so that files are not modified while archiving
+	if pause && containerInfo.Status == container.RUNNING {
+		cgroupManager := cgroups.NewCgroupManager(containerInfo.Id)
+		if err := cgroupManager.Pause(); err != nil {
+			return fmt.Errorf("Pause container %s error %v", containerName, err)
+		}
+		defer func() {
+			if err := cgroupManager.Resume(); err != nil {
+				log.Errorf("Resume container %s error %v", containerName, err)
+			}
+		}()
+	}
+
+	if options.CreatedBy == "" {
+		options.CreatedBy = containerInfo.Command
+	}
+	id, err := image.Commit(parent, driver.DiffPath(containerName), imageName, options)
+	if err != nil {
+		return err
+	}
+	log.Infof("Commit container %s -> %s", containerName, imageName)
+	fmt.Println(id)
+	return nil
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"./container"
+	"./image"
+	"./network"
+	"./volume"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+const defaultConfigPath = "/etc/mydocker/config.json"
+
+// Daemon-wide settings of mydocker, read from /etc/mydocker/config.json.
+// Environment variables override the file and global flags override both:
+//
+// {
+// "root": "/root",                    images, volumes, write layers and mount points
+// "stateDir": "/var/run/mydocker",    container info, networks and IP allocations
+// "imageDir": "/opt/images",          plain <image>.tar files imported on first use
+// "policy": "/etc/mydocker/policy.json",
+// "storageDriver": "overlay",
+// "logLevel": "info",
+// "defaultNetwork": "mydocker0"       network of containers run without --net
+// }
+type Config struct {
+	Root           string `json:"root,omitempty"`
+	StateDir       string `json:"stateDir,omitempty"`
+	ImageDir       string `json:"imageDir,omitempty"`
+	Policy         string `json:"policy,omitempty"`
+	StorageDriver  string `json:"storageDriver,omitempty"`
+	LogLevel       string `json:"logLevel,omitempty"`
+	DefaultNetwork string `json:"defaultNetwork,omitempty"`
+}
+
+// Loaded by app.Before, before any command runs
+var daemonConfig = &Config{}
+
+var configFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "config",
+		Usage:  "config file",
+		Value:  defaultConfigPath,
+		EnvVar: "MYDOCKER_CONFIG",
+	},
+	cli.StringFlag{
+		Name:   "root",
+		Usage:  "root of persistent data (default /root)",
+		EnvVar: "MYDOCKER_ROOT",
+	},
+	cli.StringFlag{
+		Name:   "state-dir",
+		Usage:  "directory of runtime state (default /var/run/mydocker)",
+		EnvVar: "MYDOCKER_STATE_DIR",
+	},
+	cli.StringFlag{
+		Name:   "image-dir",
+		Usage:  "directory of plain image tarballs (default images next to the executable)",
+		EnvVar: "MYDOCKER_IMAGE_DIR",
+	},
+	cli.StringFlag{
+		Name:   "policy",
+		Usage:  "signature policy file (default /etc/mydocker/policy.json)",
+		EnvVar: "MYDOCKER_POLICY",
+	},
+	cli.StringFlag{
+		Name:   "storage-driver",
+		Usage:  "default storage driver (overlay, aufs)",
+		EnvVar: "MYDOCKER_STORAGE_DRIVER",
+	},
+	cli.StringFlag{
+		Name:   "log-level",
+		Usage:  "log level (debug, info, warn, error)",
+		EnvVar: "MYDOCKER_LOG_LEVEL",
+	},
+	cli.StringFlag{
+		Name:   "default-network",
+		Usage:  "network of containers run without --net, none to disable",
+		EnvVar: "MYDOCKER_DEFAULT_NETWORK",
+	},
+}
+
+// Read the config file, a missing file leaves the defaults
+func loadConfig(configPath string) (*Config, error) {
+	config := &Config{}
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("Parse config %s error: %v", configPath, err)
+	}
+	return config, nil
+}
+
+// Load the config file, let environment variables and global flags
+// override it, and point every package at the configured directories
+func setUpConfig(context *cli.Context) error {
+	config, err := loadConfig(context.GlobalString("config"))
+	if err != nil {
+		return err
+	}
+	for flag, value := range map[string]*string{
+		"root":            &config.Root,
+		"state-dir":       &config.StateDir,
+		"image-dir":       &config.ImageDir,
+		"policy":          &config.Policy,
+		"storage-driver":  &config.StorageDriver,
+		"log-level":       &config.LogLevel,
+		"default-network": &config.DefaultNetwork,
+	} {
+		if v := context.GlobalString(flag); v != "" {
+			*value = v
+		}
+	}
+
+	if config.LogLevel != "" {
+		level, err := log.ParseLevel(config.LogLevel)
+		if err != nil {
+			return err
+		}
+		log.SetLevel(level)
+	}
+	if config.StorageDriver != "" {
+		if _, err := container.GetStorageDriver(config.StorageDriver); err != nil {
+			return err
+		}
+		container.DefaultStorageDriver = config.StorageDriver
+	}
+
+	// Relative paths would change meaning with the working directory
+	for _, p := range []*string{&config.Root, &config.StateDir, &config.ImageDir, &config.Policy} {
+		if *p == "" {
+			continue
+		}
+		if *p, err = filepath.Abs(*p); err != nil {
+			return err
+		}
+	}
+	if config.Root != "" {
+		container.RootUrl = config.Root
+		container.MntUrl = path.Join(config.Root, "mnt") + "/%s"
+		container.WriteLayerUrl = path.Join(config.Root, "writeLayer") + "/%s"
+		image.StoreUrl = path.Join(config.Root, "image")
+		volume.VolumeUrl = path.Join(config.Root, "volumes")
+	}
+	if config.Policy != "" {
+		image.PolicyPath = config.Policy
+	}
+	if config.StateDir != "" {
+		container.DefaultInfoLocation = config.StateDir + "/%s/"
+		network.SetStateDir(path.Join(config.StateDir, "network"))
+	}
+	if config.ImageDir == "" {
+		config.ImageDir = defaultImageDir()
+	}
+	container.ImageUrl = config.ImageDir
+
+	daemonConfig = config
+	return nil
+}
+
+// Plain image tarballs are kept in images/ next to the executable, so that
+// they are found whatever the working directory is
+func defaultImageDir() string {
+	executable, err := os.Executable()
+	if err != nil {
+		return container.ImageUrl
+	}
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+	return path.Join(path.Dir(executable), "images")
+}
+
+// Return the network to connect a container to, the default network if
+// none is given. "none" leaves the container without network.
+func networkOrDefault(name string) string {
+	if name == "" {
+		name = daemonConfig.DefaultNetwork
+	}
+	if name == "none" {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"./container"
+	"./image"
+	"./network"
+	"./volume"
+	"flag"
+	"github.com/urfave/cli"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Parse global flags like app.Run does before calling app.Before
+func configContext(t *testing.T, args []string) *cli.Context {
+	set := flag.NewFlagSet("mydocker", flag.ContinueOnError)
+	for _, f := range configFlags {
+		f.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(nil, set, nil)
+}
+
+func TestSetUpConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mydocker-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(configPath, []byte(`{
+		"root": "/srv/file",
+		"stateDir": "/run/file",
+		"imageDir": "/opt/images",
+		"policy": "/etc/file.json",
+		"storageDriver": "aufs",
+		"defaultNetwork": "filenet"
+	}`), 0644)
+
+	defer func(rootUrl, mntUrl, writeLayerUrl, infoLocation, imageUrl, driver string) {
+		container.RootUrl, container.MntUrl, container.WriteLayerUrl = rootUrl, mntUrl, writeLayerUrl
+		container.DefaultInfoLocation, container.ImageUrl, container.DefaultStorageDriver = infoLocation, imageUrl, driver
+	}(container.RootUrl, container.MntUrl, container.WriteLayerUrl, container.DefaultInfoLocation, container.ImageUrl, container.DefaultStorageDriver)
+	defer func(storeUrl, policyPath, volumeUrl, stateDir string, config *Config) {
+		image.StoreUrl, image.PolicyPath, volume.VolumeUrl = storeUrl, policyPath, volumeUrl
+		network.SetStateDir(stateDir)
+		daemonConfig = config
+	}(image.StoreUrl, image.PolicyPath, volume.VolumeUrl, network.StateDir(), daemonConfig)
+	for _, key := range []string{"MYDOCKER_CONFIG", "MYDOCKER_ROOT", "MYDOCKER_STATE_DIR", "MYDOCKER_POLICY", "MYDOCKER_DEFAULT_NETWORK"} {
+		defer os.Setenv(key, os.Getenv(key))
+		os.Unsetenv(key)
+	}
+
+	cwd, _ := os.Getwd()
+	cases := []struct {
+		name     string
+		env      map[string]string
+		args     []string
+		root     string
+		stateDir string
+		policy   string
+		network  string
+	}{
+		{
+			name:     "file",
+			root:     "/srv/file",
+			stateDir: "/run/file",
+			policy:   "/etc/file.json",
+			network:  "filenet",
+		},
+		{
+			name:     "env overrides file",
+			env:      map[string]string{"MYDOCKER_ROOT": "/srv/env", "MYDOCKER_POLICY": "/etc/env.json", "MYDOCKER_DEFAULT_NETWORK": "none"},
+			root:     "/srv/env",
+			stateDir: "/run/file",
+			policy:   "/etc/env.json",
+			network:  "none",
+		},
+		{
+			name:     "flags override env",
+			env:      map[string]string{"MYDOCKER_ROOT": "/srv/env", "MYDOCKER_POLICY": "/etc/env.json", "MYDOCKER_STATE_DIR": "/run/env"},
+			args:     []string{"--root", "/srv/flag", "--policy", "/etc/flag.json"},
+			root:     "/srv/flag",
+			stateDir: "/run/env",
+			policy:   "/etc/flag.json",
+			network:  "filenet",
+		},
+		{
+			name:     "relative flags",
+			args:     []string{"--root", "data", "--state-dir", "state", "--policy", "policy.json"},
+			root:     filepath.Join(cwd, "data"),
+			stateDir: filepath.Join(cwd, "state"),
+			policy:   filepath.Join(cwd, "policy.json"),
+			network:  "filenet",
+		},
+	}
+	for _, c := range cases {
+		os.Setenv("MYDOCKER_CONFIG", configPath)
+		for key, value := range c.env {
+			os.Setenv(key, value)
+		}
+		err := setUpConfig(configContext(t, c.args))
+		for key := range c.env {
+			os.Unsetenv(key)
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+
+		expected := map[string][2]string{
+			"RootUrl":             {container.RootUrl, c.root},
+			"MntUrl":              {container.MntUrl, c.root + "/mnt/%s"},
+			"WriteLayerUrl":       {container.WriteLayerUrl, c.root + "/writeLayer/%s"},
+			"StoreUrl":            {image.StoreUrl, c.root + "/image"},
+			"VolumeUrl":           {volume.VolumeUrl, c.root + "/volumes"},
+			"DefaultInfoLocation": {container.DefaultInfoLocation, c.stateDir + "/%s/"},
+			"network state":       {network.StateDir(), c.stateDir + "/network"},
+			"PolicyPath":          {image.PolicyPath, c.policy},
+			"ImageUrl":            {container.ImageUrl, "/opt/images"},
+			"storage driver":      {container.DefaultStorageDriver, "aufs"},
+			"default network":     {daemonConfig.DefaultNetwork, c.network},
+		}
+		for name, values := range expected {
+			if values[0] != values[1] {
+				t.Errorf("%s: %s = %q, expected %q", c.name, name, values[0], values[1])
+			}
+		}
+	}
+
+	os.Setenv("MYDOCKER_CONFIG", configPath)
+	for _, args := range [][]string{{"--storage-driver", "zfs"}, {"--log-level", "loud"}} {
+		if err := setUpConfig(configContext(t, args)); err == nil {
+			t.Errorf("invalid config %v accepted", args)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package container
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// AUFS (Advanced Multi-Layered Unification Filesystem) stacks several
+// directories, called branches, into a single mount point. It was never
+// merged into the mainline kernel, so it is kept here as a legacy driver
+// for hosts that still ship the aufs module.
+//
+// $ mount -t aufs -o dirs=/write:/image none /merged
+type AufsStorageDriver struct {
+}
+
+func (d *AufsStorageDriver) Name() string {
+	return "aufs"
+}
+
+func (d *AufsStorageDriver) Prepare(containerName string) error {
+	writeURL := diffPath(containerName)
+	if err := os.MkdirAll(writeURL, 0777); err != nil {
+		log.Errorf("Mkdir %s error %v", writeURL, err)
+		return err
+	}
+	log.Infof("$ mkdir -p %s -m 0777", writeURL)
+	return nil
+}
+
+func (d *AufsStorageDriver) Mount(containerName string, lowerDirs []string) error {
+	if err := createMountPath(containerName); err != nil {
+		return err
+	}
+
+	// The first branch is writable, the rest are read-only
+	mntURL := d.Path(containerName)
+	branches := append([]string{diffPath(containerName)}, lowerDirs...)
+	dirs := "dirs=" + strings.Join(branches, ":")
+	if err := syscall.Mount("none", mntURL, "aufs", 0, dirs); err != nil {
+		return fmt.Errorf("Mount aufs on %s error: %v", mntURL, err)
+	}
+	log.Infof("$ mount -t aufs -o %s none %s", dirs, mntURL)
+	log.Infof("AUFS: %s[rw], %s[ro] -> %s[aufs]",
+		diffPath(containerName), strings.Join(lowerDirs, ":"), mntURL)
+	return nil
+}
+
+// Every branch is read-only, there is no write layer
+func (d *AufsStorageDriver) MountReadOnly(containerName string, lowerDirs []string) error {
+	if len(lowerDirs) == 1 {
+		return bindReadOnly(containerName, lowerDirs[0])
+	}
+	if err := createMountPath(containerName); err != nil {
+		return err
+	}
+
+	mntURL := d.Path(containerName)
+	var branches []string
+	for _, dir := range lowerDirs {
+		branches = append(branches, dir+"=ro")
+	}
+	dirs := "dirs=" + strings.Join(branches, ":")
+	if err := syscall.Mount("none", mntURL, "aufs", syscall.MS_RDONLY, dirs); err != nil {
+		return fmt.Errorf("Mount aufs on %s error: %v", mntURL, err)
+	}
+	log.Infof("$ mount -t aufs -o ro,%s none %s", dirs, mntURL)
+	return nil
+}
+
+func (d *AufsStorageDriver) Unmount(containerName string) error {
+	mntURL := d.Path(containerName)
+	if err := syscall.Unmount(mntURL, syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("Unmount %s error: %v", mntURL, err)
+	}
+	log.Infof("$ umount %s", mntURL)
+	return nil
+}
+
+func (d *AufsStorageDriver) Cleanup(containerName string) error {
+	return removeLayerDirs(containerName)
+}
+
+func (d *AufsStorageDriver) Path(containerName string) string {
+	return mountPath(containerName)
+}
+
+func (d *AufsStorageDriver) DiffPath(containerName string) string {
+	return diffPath(containerName)
+}
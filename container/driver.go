@@ -0,0 +1,122 @@
+package container
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"syscall"
+)
+
+// Storage driver is a component that assembles the root filesystem of a
+// container from read-only image layers and a per-container write layer.
+// Different drivers use different union filesystems (overlayfs, AUFS) to
+// stack these layers together at the container mount point.
+//
+// Here we define the storage driver interface:
+type StorageDriver interface {
+	// Return name of driver, for example overlay
+	Name() string
+
+	// Create the per-container write layer
+	Prepare(containerName string) error
+
+	// Union lowerDirs (top-most layer first) and the write layer of the
+	// container at its mount point
+	Mount(containerName string, lowerDirs []string) error
+
+	// Union lowerDirs read-only at the mount point of the container,
+	// without a write layer
+	MountReadOnly(containerName string, lowerDirs []string) error
+
+	// Detach the union filesystem from the container mount point
+	Unmount(containerName string) error
+
+	// Remove the write layer and the mount point of the container
+	Cleanup(containerName string) error
+
+	// Return the mount point of the container rootfs
+	Path(containerName string) string
+
+	// Return the write layer holding files changed by the container
+	DiffPath(containerName string) string
+}
+
+var storageDrivers = map[string]StorageDriver{}
+
+func init() {
+	for _, driver := range []StorageDriver{
+		&OverlayStorageDriver{},
+		&AufsStorageDriver{},
+	} {
+		storageDrivers[driver.Name()] = driver
+	}
+}
+
+// Return the storage driver registered as name, an empty name selects
+// DefaultStorageDriver
+func GetStorageDriver(name string) (StorageDriver, error) {
+	if name == "" {
+		name = DefaultStorageDriver
+	}
+	driver, ok := storageDrivers[name]
+	if !ok {
+		return nil, fmt.Errorf("No Such Storage Driver: %s", name)
+	}
+	return driver, nil
+}
+
+// Return the mount point of a container rootfs, it is shared by all drivers
+func mountPath(containerName string) string {
+	return fmt.Sprintf(MntUrl, containerName)
+}
+
+// Return the directory keeping the files written by a container
+func diffPath(containerName string) string {
+	return fmt.Sprintf(WriteLayerUrl, containerName) + "/diff"
+}
+
+func createMountPath(containerName string) error {
+	mntURL := mountPath(containerName)
+	if err := os.MkdirAll(mntURL, 0777); err != nil {
+		log.Errorf("Mkdir %s error %v", mntURL, err)
+		return err
+	}
+	log.Infof("$ mkdir -p %s -m 0777", mntURL)
+	return nil
+}
+
+// Bind mount a single layer read-only at the mount point of a container,
+// union filesystems need at least two layers without a write layer
+func bindReadOnly(containerName, dir string) error {
+	if err := createMountPath(containerName); err != nil {
+		return err
+	}
+	mntURL := mountPath(containerName)
+	if err := syscall.Mount(dir, mntURL, "", syscall.MS_BIND, ""); err != nil {
+		return fmt.Errorf("Bind mount %s on %s error: %v", dir, mntURL, err)
+	}
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	if err := syscall.Mount("", mntURL, "", flags, ""); err != nil {
+		syscall.Unmount(mntURL, syscall.MNT_DETACH)
+		return fmt.Errorf("Remount %s read-only error: %v", mntURL, err)
+	}
+	log.Infof("$ mount --bind -o ro %s %s", dir, mntURL)
+	return nil
+}
+
+func removeLayerDirs(containerName string) error {
+	mntURL := mountPath(containerName)
+	if err := os.RemoveAll(mntURL); err != nil {
+		log.Errorf("Remove dir %s error %v", mntURL, err)
+		return err
+	}
+	log.Infof("$ rm -rf %s", mntURL)
+
+	writeURL := fmt.Sprintf(WriteLayerUrl, containerName)
+	if err := os.RemoveAll(writeURL); err != nil {
+		log.Errorf("Remove dir %s error %v", writeURL, err)
+		return err
+	}
+	log.Infof("$ rm -rf %s", writeURL)
+	return nil
+}
@@ -6,26 +6,56 @@ import (
 )
 
 type ContainerInfo struct {
-	Pid         string   `json:"pid"`         // Conainter init process PID on host sys
-	Id          string   `json:"id"`          // Container ID
-	Name        string   `json:"name"`        // Container name
-	Command     string   `json:"command"`     // Command to be executed by init action
-	CreatedTime string   `json:"createTime"`  // Create time
-	Status      string   `json:"status"`      // Container status
-	Volume      string   `json:"volume"`      // Container volume
-	PortMapping []string `json:"portmapping"` // Port mapping
+	Pid           string            `json:"pid"`                    // Conainter init process PID on host sys
+	Id            string            `json:"id"`                     // Container ID
+	Name          string            `json:"name"`                   // Container name
+	Command       string            `json:"command"`                // Command to be executed by init action
+	CreatedTime   string            `json:"createTime"`             // Create time
+	Status        string            `json:"status"`                 // Container status
+	Volume        string            `json:"volume,omitempty"`       // Single volume of containers created by older versions
+	Mounts        []Mount           `json:"mounts,omitempty"`       // Bind, volume and tmpfs mounts
+	PortMapping   []string          `json:"portmapping"`            // Port mapping
+	StorageDriver string            `json:"storageDriver"`          // Storage driver of rootfs
+	StorageSize   int64             `json:"storageSize,omitempty"`  // Size limit of the write layer in bytes
+	StorageQuota  string            `json:"storageQuota,omitempty"` // How the size limit is enforced, project or loopback
+	ImageName     string            `json:"image"`                  // Image reference
+	ImageId       string            `json:"imageId"`                // Image ID in image store
+	Rootfs        string            `json:"rootfs,omitempty"`       // Directory used instead of an image
+	ReadOnly      bool              `json:"readOnly,omitempty"`     // Rootfs mounted read-only, without write layer
+	Labels        map[string]string `json:"labels,omitempty"`       // Labels of image and container
+	StopSignal    string            `json:"stopSignal,omitempty"`   // Signal sent by stop, SIGTERM if empty
 }
 
 type ContainerConfig struct {
-	TTY         bool
-	Name        string
-	ID          string
-	Volume      string
-	ImageName   string
-	Env         []string
-	NetworkName string
-	PortMapping []string
-	Pipe        *os.File
-	CmdArray    []string
-	Resource    *subsystems.ResourceConfig
+	TTY           bool
+	Name          string
+	ID            string
+	Mounts        []Mount // Mounts of -v and --mount, volumes resolved to their directory
+	ImageName     string
+	Rootfs        string // Directory used as read-only layer instead of an image
+	ReadOnly      bool   // Mount the rootfs read-only, without write layer
+	Env           []string
+	NetworkName   string
+	PortMapping   []string
+	StorageDriver string
+	StorageSize   int64  // Size limit of the write layer, 0 is unlimited
+	StorageQuota  string // Set by NewWorkSpace to the quota method used
+	WorkingDir    string
+	User          string
+	Labels        map[string]string
+	StopSignal    string
+	SkipVerify    bool // Run the image even if the signature policy rejects it
+	Pipe          *os.File
+	CmdArray      []string
+	Resource      *subsystems.ResourceConfig
+}
+
+// InitConfig is sent by the parent process to the container init process
+// through the pipe, the command is kept as an exact argument list
+type InitConfig struct {
+	Cmd        []string `json:"cmd"`
+	WorkingDir string   `json:"workingDir,omitempty"`
+	User       string   `json:"user,omitempty"`
+	Mounts     []Mount  `json:"mounts,omitempty"`
+	ReadOnly   bool     `json:"readOnly,omitempty"`
 }
@@ -1,13 +1,13 @@
 package container
 
 import (
+	"encoding/json"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 	"syscall"
 )
 
@@ -17,16 +17,32 @@ import (
 // the system command like `ps` can read the process status.
 
 func RunContainerInitProcess() error {
-	log.Infof("Setup filesystem mount point")
-	setUpMount()
+	// Read user commands and mounts from pipe
+	initConfig, err := readInitConfig()
+	if err != nil {
+		return err
+	}
 
-	// Read user commands from pipe
-	cmdArray := readUserCommand()
-	if cmdArray == nil || len(cmdArray) == 0 {
+	log.Infof("Setup filesystem mount point")
+	if err := setUpMount(initConfig); err != nil {
+		return err
+	}
+	cmdArray := initConfig.Cmd
+	if len(cmdArray) == 0 {
 		return fmt.Errorf("Run container get user command error, cmdArray is nil")
 	}
 	log.Infof("Get: pipe -> %v", cmdArray)
 
+	if initConfig.WorkingDir != "" {
+		if err := os.MkdirAll(initConfig.WorkingDir, 0755); err != nil {
+			return fmt.Errorf("Create working dir %s error %v", initConfig.WorkingDir, err)
+		}
+		if err := syscall.Chdir(initConfig.WorkingDir); err != nil {
+			return fmt.Errorf("Change to working dir %s error %v", initConfig.WorkingDir, err)
+		}
+		log.Infof("$ cd %s", initConfig.WorkingDir)
+	}
+
 	// Since syscall.execve require absolute path of command, here we
 	// find command absolute path in system PATH env using exec.LookPath
 	// Example: fish -> /usr/bin/fish
@@ -39,6 +55,13 @@ func RunContainerInitProcess() error {
 	}
 	log.Infof("\"%s\" -> \"%s\"", cmdArray[0], path)
 
+	// Switch user last, the root user may be needed to find the command
+	if initConfig.User != "" {
+		if err := switchUser(initConfig.User); err != nil {
+			return err
+		}
+	}
+
 	// `os.syscall.Exec` invokes Linux execve(2) system call
 	//
 	// execve(2) executes the program pointed to by filename.  This causes
@@ -53,7 +76,7 @@ func RunContainerInitProcess() error {
 	return nil
 }
 
-func readUserCommand() []string {
+func readInitConfig() (*InitConfig, error) {
 
 	// There are three standard file descriptions, STDIN, STDOUT, and STDERR.
 	// They are assigned to 0, 1, and 2 respectively.
@@ -71,22 +94,37 @@ func readUserCommand() []string {
 	msg, err := ioutil.ReadAll(pipe)
 	if err != nil {
 		log.Errorf("Init read pipe error %v", err)
-		return nil
+		return nil, err
 	}
-	msgStr := string(msg)
-	return strings.Split(msgStr, " ")
+	var initConfig InitConfig
+	if err := json.Unmarshal(msg, &initConfig); err != nil {
+		return nil, fmt.Errorf("Init parse config %q error %v", msg, err)
+	}
+	return &initConfig, nil
 }
 
 // Initialize mount point
-func setUpMount() {
+func setUpMount(initConfig *InitConfig) error {
 	pwd, err := os.Getwd()
 	if err != nil {
 		log.Errorf("Get current location error %v", err)
-		return
+		return err
 	}
 	log.Infof("$ pwd = %s", pwd)
 
-	pivotRoot(pwd)
+	// Mounts of the container must be in place, a missing volume is an error
+	if err := pivotRoot(pwd, initConfig.Mounts, initConfig.ReadOnly); err != nil {
+		return err
+	}
+
+	// Mounts keep their own flags, so volumes and tmpfs stay writable
+	if initConfig.ReadOnly {
+		flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+		if err := syscall.Mount("", "/", "", flags, ""); err != nil {
+			return fmt.Errorf("Remount / read-only error: %v", err)
+		}
+		log.Infof("$ mount -o remount,ro,bind /")
+	}
 
 	// Remount "/proc" to get accurate "top" && "ps" output
 	// Meaning of mount flags:
@@ -102,10 +140,10 @@ func setUpMount() {
 
 	if err := syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755"); err != nil {
 		log.Errorf("Mount tmpfs error: %v", err)
-		return
 	} else {
 		log.Infof("$ mount tmpfs tmpfs /dev -o nosuid,strictatime mode=755")
 	}
+	return nil
 }
 
 // pivot_root() moves the root file system of the calling process to the directory
@@ -149,7 +187,7 @@ func setUpMount() {
 //    $ mount --move /oldroot/sys  /sys
 //    $ mount --move /oldroot/proc /proc
 
-func pivotRoot(root string) error {
+func pivotRoot(root string, mounts []Mount, readOnly bool) error {
 
 	// Under Linux, bind mounts are available as a kernel feature. You can create one
 	// with the mount command, by passing either the `--bind` command line option or
@@ -203,11 +241,17 @@ func pivotRoot(root string) error {
 	// mount --bind /some/where /else/where
 	// mount -o remount,ro,bind /else/where
 
-	if err := syscall.Mount("none", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
-		log.Errorf("Command Failed: mount --make-rprivate /")
+	// Slave and shared bind mounts only receive mount events from the host
+	// if the root of the namespace is a slave, rather than private
+	rootPropagation := "rprivate"
+	if needSlaveRoot(mounts) {
+		rootPropagation = "rslave"
+	}
+	if err := syscall.Mount("none", "/", "", propagationFlags[rootPropagation], ""); err != nil {
+		log.Errorf("Command Failed: mount --make-%s /", rootPropagation)
 		return err
 	} else {
-		log.Infof("$ mount --make-rprivate /")
+		log.Infof("$ mount --make-%s /", rootPropagation)
 	}
 
 	/**
@@ -220,6 +264,18 @@ func pivotRoot(root string) error {
 		log.Infof("$ mount --bind %s /", root)
 	}
 
+	// Mount volumes while the host is still reachable, they stay private
+	// to the mount namespace of the container
+	if err := setUpMounts(root, mounts); err != nil {
+		return err
+	}
+
+	// A read-only root has no room for .pivot_root. The old root can be
+	// stacked on the new one instead, and detached from there.
+	if readOnly {
+		return pivotRootInPlace(root)
+	}
+
 	// 创建 rootfs/.pivot_root 存储 old_root
 	pivotDir := filepath.Join(root, ".pivot_root")
 	if err := os.Mkdir(pivotDir, 0777); err != nil {
@@ -266,6 +322,26 @@ func pivotRoot(root string) error {
 	return nil
 }
 
+// $ cd root && pivot_root . . && umount -l .
+func pivotRootInPlace(root string) error {
+	if err := syscall.Chdir(root); err != nil {
+		return fmt.Errorf("\"cd %s\": %v", root, err)
+	}
+	if err := syscall.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("pivot_root %v", err)
+	}
+	log.Infof("$ pivot_root . .")
+	if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("unmount old root %v", err)
+	}
+	log.Infof("$ umount -l .")
+	if err := syscall.Chdir("/"); err != nil {
+		return fmt.Errorf("\"cd / \": %v", err)
+	}
+	log.Infof("$ cd /")
+	return nil
+}
+
 // execve("./mydocker", ["./mydocker", "run", "-ti", "fish"], [/* 30 vars */]) = 0
 // clone(child_stack=0xc420046000, flags=CLONE_VM|CLONE_FS|CLONE_FILES|CLONE_SIGHAND|CLONE_THREAD|CLONE_SYSVSEM) = 113
 // clone(child_stack=0xc420048000, flags=CLONE_VM|CLONE_FS|CLONE_FILES|CLONE_SIGHAND|CLONE_THREAD|CLONE_SYSVSEM) = 114
@@ -0,0 +1,395 @@
+package container
+
+import (
+	"../archive"
+	"../misc"
+	"../volume"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Volumes provide the best and most predictable performance for write-heavy workloads.
+// This is because they bypass the storage driver and do not incur any of the potential
+// overheads introduced by thin provisioning and copy-on-write. Volumes have other
+// benefits, such as allowing you to share data among containers and persisting even
+// when no running container is using them.
+//
+// Mounts are set up by the init process inside the mount namespace of the
+// container, before pivot_root, so they are not visible on the host and go
+// away with the container.
+const (
+	MountTypeBind   = "bind"
+	MountTypeVolume = "volume"
+	MountTypeTmpfs  = "tmpfs"
+)
+
+type Mount struct {
+	Type        string   `json:"type"`                  // bind, volume or tmpfs
+	Source      string   `json:"source,omitempty"`      // Host path or volume name
+	Path        string   `json:"path,omitempty"`        // Host directory mounted, resolved from Source
+	Destination string   `json:"destination"`           // Absolute path inside the container
+	ReadOnly    bool     `json:"readOnly,omitempty"`    //
+	Propagation string   `json:"propagation,omitempty"` // Bind propagation, rprivate by default
+	TmpfsSize   int64    `json:"tmpfsSize,omitempty"`   // Size limit of a tmpfs in bytes, 0 is unlimited
+	TmpfsMode   uint32   `json:"tmpfsMode,omitempty"`   // Permissions of the tmpfs root, 1777 by default
+	Options     []string `json:"options,omitempty"`     // Flags of a tmpfs like noexec, applied in order
+}
+
+// Flags of tmpfs options, the options clearing a flag map to 0
+var tmpfsFlags = map[string]uintptr{
+	"noexec": syscall.MS_NOEXEC,
+	"exec":   0,
+	"nosuid": syscall.MS_NOSUID,
+	"suid":   0,
+	"nodev":  syscall.MS_NODEV,
+	"dev":    0,
+}
+
+var propagationFlags = map[string]uintptr{
+	"private":  syscall.MS_PRIVATE,
+	"rprivate": syscall.MS_PRIVATE | syscall.MS_REC,
+	"slave":    syscall.MS_SLAVE,
+	"rslave":   syscall.MS_SLAVE | syscall.MS_REC,
+	"shared":   syscall.MS_SHARED,
+	"rshared":  syscall.MS_SHARED | syscall.MS_REC,
+}
+
+// Parse `-v source:destination[:ro|rw]`. A source which is not a path is
+// the name of a volume. A missing host directory is created like Docker
+// does for -v.
+func ParseVolumeFlag(spec string) (*Mount, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("Invalid volume %q, expect source:destination[:ro]", spec)
+	}
+	m := &Mount{
+		Type:        MountTypeBind,
+		Source:      parts[0],
+		Destination: parts[1],
+	}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "ro":
+			m.ReadOnly = true
+		case "rw":
+		default:
+			return nil, fmt.Errorf("Invalid volume mode %q in %q", parts[2], spec)
+		}
+	}
+	if volume.IsNamed(m.Source) {
+		m.Type = MountTypeVolume
+	} else if err := os.MkdirAll(m.Source, 0755); err != nil {
+		return nil, err
+	}
+	return m, m.validate()
+}
+
+// Parse `--mount type=bind|volume|tmpfs,src=...,dst=...,readonly,...`
+// with the keys of Docker. The type defaults to volume.
+func ParseMountFlag(spec string) (*Mount, error) {
+	m := &Mount{Type: MountTypeVolume}
+	for _, field := range strings.Split(spec, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		key, value := strings.ToLower(strings.TrimSpace(kv[0])), ""
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+		switch key {
+		case "type":
+			m.Type = value
+		case "source", "src":
+			m.Source = value
+		case "destination", "dst", "target":
+			m.Destination = value
+		case "readonly", "ro":
+			readOnly, err := parseBoolOption(key, value)
+			if err != nil {
+				return nil, err
+			}
+			m.ReadOnly = readOnly
+		case "bind-propagation":
+			m.Propagation = value
+		case "tmpfs-size":
+			size, err := ParseSize(value)
+			if err != nil {
+				return nil, err
+			}
+			m.TmpfsSize = size
+		case "tmpfs-mode":
+			mode, err := strconv.ParseUint(value, 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid tmpfs-mode %q", value)
+			}
+			m.TmpfsMode = uint32(mode)
+		case "volume-nocopy":
+			// Volumes are never populated from the image
+		default:
+			return nil, fmt.Errorf("Unknown mount option %q in %q", key, spec)
+		}
+	}
+	if m.Type == MountTypeBind {
+		if m.Source == "" {
+			return nil, fmt.Errorf("Bind mount %q requires a source", spec)
+		}
+		if _, err := os.Stat(m.Source); err != nil {
+			return nil, fmt.Errorf("Bind source path %s does not exist", m.Source)
+		}
+	}
+	return m, m.validate()
+}
+
+// Parse `--tmpfs /path[:size=64m,mode=1777,noexec,...]`. Like Docker, the
+// tmpfs is noexec unless exec is given.
+func ParseTmpfsFlag(spec string) (*Mount, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	m := &Mount{
+		Type:        MountTypeTmpfs,
+		Destination: parts[0],
+		Options:     []string{"noexec"},
+	}
+	if len(parts) == 2 {
+		for _, option := range strings.Split(parts[1], ",") {
+			kv := strings.SplitN(option, "=", 2)
+			switch {
+			case option == "":
+			case kv[0] == "size" && len(kv) == 2:
+				size, err := ParseSize(kv[1])
+				if err != nil {
+					return nil, err
+				}
+				m.TmpfsSize = size
+			case kv[0] == "mode" && len(kv) == 2:
+				mode, err := strconv.ParseUint(kv[1], 8, 32)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid tmpfs mode %q", kv[1])
+				}
+				m.TmpfsMode = uint32(mode)
+			case option == "ro" || option == "rw":
+				m.ReadOnly = option == "ro"
+			default:
+				if _, ok := tmpfsFlags[option]; !ok {
+					return nil, fmt.Errorf("Unknown tmpfs option %q in %q", option, spec)
+				}
+				m.Options = append(m.Options, option)
+			}
+		}
+	}
+	return m, m.validate()
+}
+
+func parseBoolOption(key, value string) (bool, error) {
+	if value == "" {
+		return true, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid value %q of mount option %s", value, key)
+	}
+	return b, nil
+}
+
+func (m *Mount) validate() error {
+	switch m.Type {
+	case MountTypeBind, MountTypeVolume, MountTypeTmpfs:
+	default:
+		return fmt.Errorf("Unknown mount type %q", m.Type)
+	}
+	if m.Destination == "" {
+		return fmt.Errorf("Mount of %s requires a destination", m.Source)
+	}
+	m.Destination = path.Join("/", m.Destination)
+	if m.Destination == "/" {
+		return fmt.Errorf("Cannot mount over / of the container")
+	}
+	if m.Type == MountTypeTmpfs && m.Source != "" {
+		return fmt.Errorf("Tmpfs mount on %s does not take a source", m.Destination)
+	}
+	if m.Type != MountTypeTmpfs && (m.TmpfsSize != 0 || m.TmpfsMode != 0 || len(m.Options) > 0) {
+		return fmt.Errorf("Tmpfs options given to %s mount on %s", m.Type, m.Destination)
+	}
+	if m.Propagation != "" {
+		if m.Type != MountTypeBind {
+			return fmt.Errorf("bind-propagation only applies to bind mounts")
+		}
+		if _, ok := propagationFlags[m.Propagation]; !ok {
+			return fmt.Errorf("Invalid bind-propagation %q", m.Propagation)
+		}
+	}
+	if m.Type == MountTypeBind {
+		source, err := filepath.Abs(m.Source)
+		if err != nil {
+			return err
+		}
+		m.Source, m.Path = source, source
+	}
+	return nil
+}
+
+// Parse a size like 64m or 1g into bytes
+func ParseSize(size string) (int64, error) {
+	units := map[byte]int64{'k': 1 << 10, 'm': 1 << 20, 'g': 1 << 30}
+	value := strings.ToLower(strings.TrimSuffix(strings.ToLower(size), "b"))
+	multiplier := int64(1)
+	if value != "" {
+		if unit, ok := units[value[len(value)-1]]; ok {
+			multiplier, value = unit, value[:len(value)-1]
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("Invalid size %q", size)
+	}
+	return n * multiplier, nil
+}
+
+// Whether the root of the container should receive mount events of the
+// host, which slave and shared mounts rely on
+func needSlaveRoot(mounts []Mount) bool {
+	for _, m := range mounts {
+		if strings.Contains(m.Propagation, "slave") || strings.Contains(m.Propagation, "shared") {
+			return true
+		}
+	}
+	return false
+}
+
+// Mount every mount under root, the rootfs of the container before
+// pivot_root. Parents are mounted before the mounts nested in them.
+func setUpMounts(root string, mounts []Mount) error {
+	for _, m := range mounts {
+		// A symlink of the image cannot point the mount outside of root
+		target, err := archive.ResolveInRoot(root, m.Destination)
+		if err != nil {
+			return err
+		}
+		switch m.Type {
+		case MountTypeTmpfs:
+			err = mountTmpfs(target, &m)
+		default:
+			err = mountBind(target, &m)
+		}
+		if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.EROFS {
+			return fmt.Errorf("Mount point %s does not exist in the read-only rootfs", m.Destination)
+		}
+		if err != nil {
+			return fmt.Errorf("Mount %s on %s error: %v", m.Source, m.Destination, err)
+		}
+	}
+	return nil
+}
+
+func mountBind(target string, m *Mount) error {
+	info, err := os.Stat(m.Path)
+	if err != nil {
+		return err
+	}
+	// A file is bind mounted on a file
+	if info.IsDir() {
+		err = os.MkdirAll(target, 0755)
+	} else if err = os.MkdirAll(filepath.Dir(target), 0755); err == nil {
+		var file *os.File
+		if file, err = os.OpenFile(target, os.O_CREATE|os.O_RDONLY, 0644); err == nil {
+			file.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Mount(m.Path, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return err
+	}
+	log.Infof("$ mount --rbind %s %s", m.Path, target)
+	if m.ReadOnly {
+		// Read-only needs a second step, see pivotRoot
+		flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+		if err := syscall.Mount("", target, "", flags, ""); err != nil {
+			return err
+		}
+		log.Infof("$ mount -o remount,ro,bind %s", target)
+	}
+	propagation := m.Propagation
+	if propagation == "" {
+		propagation = "rprivate"
+	}
+	if err := syscall.Mount("", target, "", propagationFlags[propagation], ""); err != nil {
+		return err
+	}
+	log.Infof("$ mount --make-%s %s", propagation, target)
+	return nil
+}
+
+// Mount a tmpfs, nosuid and nodev unless its options say otherwise. Its
+// pages are charged to the memory cgroup of the processes writing them,
+// the init process has joined the cgroup of the container by now.
+func mountTmpfs(target string, m *Mount) error {
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	mode := m.TmpfsMode
+	if mode == 0 {
+		mode = 01777
+	}
+	options := fmt.Sprintf("mode=%o", mode)
+	if m.TmpfsSize > 0 {
+		options += fmt.Sprintf(",size=%d", m.TmpfsSize)
+	}
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV)
+	for _, option := range m.Options {
+		switch option {
+		case "exec":
+			flags &^= syscall.MS_NOEXEC
+		case "suid":
+			flags &^= syscall.MS_NOSUID
+		case "dev":
+			flags &^= syscall.MS_NODEV
+		default:
+			flags |= tmpfsFlags[option]
+		}
+	}
+	if m.ReadOnly {
+		flags |= syscall.MS_RDONLY
+	}
+	if err := syscall.Mount("tmpfs", target, "tmpfs", flags, options); err != nil {
+		return err
+	}
+	log.Infof("$ mount -t tmpfs -o %s tmpfs %s", options, target)
+	return nil
+}
+
+// Return the mounts of a container, including the single volume of
+// containers created before mounts were recorded
+func (info *ContainerInfo) AllMounts() []Mount {
+	if len(info.Mounts) > 0 || info.Volume == "" {
+		return info.Mounts
+	}
+	parts := strings.Split(info.Volume, ":")
+	if len(parts) != 2 {
+		return nil
+	}
+	return []Mount{{Type: MountTypeBind, Source: parts[0], Path: parts[0], Destination: path.Join("/", parts[1])}}
+}
+
+// Detach mounts left on the host under the rootfs of a container, the
+// deepest first. Mounts made by the init process vanish with the mount
+// namespace, only volumes of containers created by older versions were
+// mounted from the host.
+func unmountAll(containerName string, mounts []Mount) {
+	for i := len(mounts) - 1; i >= 0; i-- {
+		target := filepath.Join(mountPath(containerName), mounts[i].Destination)
+		if mounted, _ := misc.IsMounted(target); !mounted {
+			continue
+		}
+		if err := syscall.Unmount(target, syscall.MNT_DETACH); err != nil {
+			log.Errorf("Unmount %s error %v", target, err)
+			continue
+		}
+		log.Infof("$ umount %s", target)
+	}
+}
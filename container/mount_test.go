@@ -0,0 +1,118 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestParseVolumeFlag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mydocker-mount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := ParseVolumeFlag(dir + "/data:/var/lib/data:ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != MountTypeBind || m.Path != dir+"/data" || m.Destination != "/var/lib/data" || !m.ReadOnly {
+		t.Errorf("bind volume = %+v", m)
+	}
+	if _, err := os.Stat(dir + "/data"); err != nil {
+		t.Errorf("host directory not created: %v", err)
+	}
+
+	if m, err := ParseVolumeFlag("cache:/cache"); err != nil || m.Type != MountTypeVolume || m.Source != "cache" {
+		t.Errorf("named volume = %+v, %v", m, err)
+	}
+	for _, spec := range []string{"/data", ":/data", "/a:/b:rx", "/a:/b:ro:x", "/a:/"} {
+		if _, err := ParseVolumeFlag(spec); err == nil {
+			t.Errorf("invalid volume %q accepted", spec)
+		}
+	}
+}
+
+func TestParseMountFlag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mydocker-mount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m, err := ParseMountFlag("type=bind,src=" + dir + ",dst=/srv,readonly,bind-propagation=rslave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Path != dir || m.Destination != "/srv" || !m.ReadOnly || m.Propagation != "rslave" {
+		t.Errorf("bind mount = %+v", m)
+	}
+	if !needSlaveRoot([]Mount{*m}) {
+		t.Errorf("rslave mount does not need a slave root")
+	}
+
+	m, err = ParseMountFlag("type=tmpfs,target=/run,tmpfs-size=64m,tmpfs-mode=700")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.TmpfsSize != 64<<20 || m.TmpfsMode != 0700 {
+		t.Errorf("tmpfs mount = %+v", m)
+	}
+
+	if m, err := ParseMountFlag("dst=/data"); err != nil || m.Type != MountTypeVolume || m.Source != "" {
+		t.Errorf("anonymous volume = %+v, %v", m, err)
+	}
+
+	invalid := []string{
+		"type=bind,src=" + dir + "/missing,dst=/srv",
+		"type=bind,dst=/srv",
+		"type=tmpfs,src=/tmp,dst=/tmp",
+		"type=volume,src=data,dst=/data,bind-propagation=rshared",
+		"type=bind,src=" + dir + ",dst=/srv,bind-propagation=both",
+		"type=volume,src=data,dst=/data,tmpfs-size=1m",
+		"type=nfs,dst=/data",
+		"src=data",
+		"dst=/data,colour=blue",
+	}
+	for _, spec := range invalid {
+		if _, err := ParseMountFlag(spec); err == nil {
+			t.Errorf("invalid mount %q accepted", spec)
+		}
+	}
+}
+
+func TestParseTmpfsFlag(t *testing.T) {
+	m, err := ParseTmpfsFlag("/scratch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != MountTypeTmpfs || m.Destination != "/scratch" || len(m.Options) != 1 || m.Options[0] != "noexec" {
+		t.Errorf("default tmpfs = %+v", m)
+	}
+
+	m, err = ParseTmpfsFlag("/scratch:size=1g,mode=1770,exec,nodev,ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.TmpfsSize != 1<<30 || m.TmpfsMode != 01770 || !m.ReadOnly {
+		t.Errorf("tmpfs = %+v", m)
+	}
+	if len(m.Options) != 3 || m.Options[1] != "exec" || m.Options[2] != "nodev" {
+		t.Errorf("tmpfs options = %v", m.Options)
+	}
+
+	for _, spec := range []string{"", ":size=1m", "/", "/scratch:size=lots", "/scratch:mode=999", "/scratch:nosuchopt"} {
+		if _, err := ParseTmpfsFlag(spec); err == nil {
+			t.Errorf("invalid tmpfs %q accepted", spec)
+		}
+	}
+}
+
+func TestAllMounts(t *testing.T) {
+	info := &ContainerInfo{Volume: "/root/data:/data"}
+	mounts := info.AllMounts()
+	if len(mounts) != 1 || mounts[0].Path != "/root/data" || mounts[0].Destination != "/data" {
+		t.Errorf("legacy volume = %+v", mounts)
+	}
+}
@@ -0,0 +1,100 @@
+package container
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// OverlayFS is merged into the mainline kernel since Linux 3.18, it unions
+// one or more read-only lower directories with a writable upper directory.
+// The work directory is used by overlayfs internally to prepare files before
+// they are switched into the upper directory, so it must be an empty directory
+// on the same filesystem as the upper directory.
+//
+// $ mount -t overlay overlay -o lowerdir=/lower1:/lower2,upperdir=/upper,workdir=/work /merged
+type OverlayStorageDriver struct {
+}
+
+func (d *OverlayStorageDriver) Name() string {
+	return "overlay"
+}
+
+func (d *OverlayStorageDriver) Prepare(containerName string) error {
+	for _, dir := range []string{diffPath(containerName), d.workPath(containerName)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Errorf("Mkdir %s error %v", dir, err)
+			return err
+		}
+		log.Infof("$ mkdir -p %s -m 0755", dir)
+	}
+	return nil
+}
+
+func (d *OverlayStorageDriver) Mount(containerName string, lowerDirs []string) error {
+	if len(lowerDirs) == 0 {
+		return fmt.Errorf("overlay requires at least one lower dir")
+	}
+	if err := createMountPath(containerName); err != nil {
+		return err
+	}
+
+	mntURL := d.Path(containerName)
+	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+		strings.Join(lowerDirs, ":"), diffPath(containerName), d.workPath(containerName))
+	if err := syscall.Mount("overlay", mntURL, "overlay", 0, options); err != nil {
+		return fmt.Errorf("Mount overlay on %s error: %v", mntURL, err)
+	}
+	log.Infof("$ mount -t overlay overlay -o %s %s", options, mntURL)
+	log.Infof("OverlayFS: %s[rw], %s[ro] -> %s[overlay]",
+		diffPath(containerName), strings.Join(lowerDirs, ":"), mntURL)
+	return nil
+}
+
+// Without upperdir and workdir, overlayfs is read-only
+func (d *OverlayStorageDriver) MountReadOnly(containerName string, lowerDirs []string) error {
+	if len(lowerDirs) == 0 {
+		return fmt.Errorf("overlay requires at least one lower dir")
+	}
+	if len(lowerDirs) == 1 {
+		return bindReadOnly(containerName, lowerDirs[0])
+	}
+	if err := createMountPath(containerName); err != nil {
+		return err
+	}
+
+	mntURL := d.Path(containerName)
+	options := "lowerdir=" + strings.Join(lowerDirs, ":")
+	if err := syscall.Mount("overlay", mntURL, "overlay", syscall.MS_RDONLY, options); err != nil {
+		return fmt.Errorf("Mount overlay on %s error: %v", mntURL, err)
+	}
+	log.Infof("$ mount -t overlay overlay -o ro,%s %s", options, mntURL)
+	return nil
+}
+
+func (d *OverlayStorageDriver) Unmount(containerName string) error {
+	mntURL := d.Path(containerName)
+	if err := syscall.Unmount(mntURL, syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("Unmount %s error: %v", mntURL, err)
+	}
+	log.Infof("$ umount %s", mntURL)
+	return nil
+}
+
+func (d *OverlayStorageDriver) Cleanup(containerName string) error {
+	return removeLayerDirs(containerName)
+}
+
+func (d *OverlayStorageDriver) Path(containerName string) string {
+	return mountPath(containerName)
+}
+
+func (d *OverlayStorageDriver) workPath(containerName string) string {
+	return fmt.Sprintf(WriteLayerUrl, containerName) + "/work"
+}
+
+func (d *OverlayStorageDriver) DiffPath(containerName string) string {
+	return diffPath(containerName)
+}
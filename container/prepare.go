@@ -23,7 +23,7 @@ import (
 ///  ...            ...
 // }
 
-func NewParentProcess(tty bool, containerName, volume, imageName string, envSlice []string) (*exec.Cmd, *os.File) {
+func NewParentProcess(config *ContainerConfig) (*exec.Cmd, *os.File) {
 	// NewParentProcess will fork a new process with argument `init`
 	//
 	// PID  COMMAND
@@ -54,14 +54,14 @@ func NewParentProcess(tty bool, containerName, volume, imageName string, envSlic
 	}
 	// If tty is enabled (command parameter `ti`), terminal stdio is redirected
 	// to current process
-	if tty {
+	if config.TTY {
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 	} else {
 		// DETACH MODE
 		// Create log directory
-		dirURL := fmt.Sprintf(DefaultInfoLocation, containerName)
+		dirURL := fmt.Sprintf(DefaultInfoLocation, config.Name)
 		if err := os.MkdirAll(dirURL, 0622); err != nil {
 			log.Warnf("$ %v", err)
 		} else {
@@ -87,12 +87,15 @@ func NewParentProcess(tty bool, containerName, volume, imageName string, envSlic
 	log.Infof("Container.NSFlag: UTS|PID|NS(MNT)|NET|IPC")
 	cmd.ExtraFiles = []*os.File{readPipe}
 	log.Infof("Container.Files : %s", "readPipe")
-	cmd.Env = append(os.Environ(), envSlice...)
-	log.Infof("Container.Env   : %v", envSlice)
-	cmd.Dir = fmt.Sprintf(MntUrl, containerName)
+	cmd.Env = append(os.Environ(), config.Env...)
+	log.Infof("Container.Env   : %v", config.Env)
+	cmd.Dir = fmt.Sprintf(MntUrl, config.Name)
 	log.Infof("Container.Dir   : %s", cmd.Dir)
 
-	NewWorkSpace(volume, imageName, containerName)
+	if err := NewWorkSpace(config); err != nil {
+		log.Errorf("New workspace error %v", err)
+		return nil, nil
+	}
 
 	// return `Cmd` struct
 	return cmd, writePipe
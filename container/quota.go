@@ -0,0 +1,349 @@
+package container
+
+import (
+	"../archive"
+	"../misc"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// The write layer of a container, WriteLayerUrl/<name>, can be limited in
+// size by `run --storage-opt size=2G`. On XFS, and on ext4 mounted with
+// prjquota, the directory gets a project ID inherited by everything created
+// under it, and the project is given a block limit. Other filesystems get a
+// sparse ext4 image of the requested size, loop mounted on the directory:
+//
+// /root/writeLayer/.backingFsBlockDev   device node of the filesystem, for quotactl
+// /root/writeLayer/.quota.lock          serializes project ID allocation
+// /root/writeLayer/<name>.img           ext4 image of the loopback fallback
+const (
+	QuotaProject  = "project"
+	QuotaLoopback = "loopback"
+
+	xfsSuperMagic  = 0x58465342
+	ext4SuperMagic = 0xEF53
+
+	fsIocFsgetxattr    = 0x801c581f // _IOR('X', 31, struct fsxattr)
+	fsIocFssetxattr    = 0x401c5820 // _IOW('X', 32, struct fsxattr)
+	fsXflagProjinherit = 0x200
+
+	qXSetQLim       = 0x5804 // Q_XSETQLIM
+	prjQuota        = 2      // PRJQUOTA
+	fsDquotVersion  = 1
+	fsProjQuota     = 2
+	fsDqBSoft       = 1 << 2
+	fsDqBHard       = 1 << 3
+	quotaBlockShift = 9 // Quota blocks are 512 bytes
+)
+
+// struct fsxattr of <linux/fs.h>
+type fsxattr struct {
+	xflags     uint32
+	extsize    uint32
+	nextents   uint32
+	projid     uint32
+	cowextsize uint32
+	pad        [8]byte
+}
+
+// struct fs_disk_quota of <linux/dqblk_xfs.h>
+type fsDiskQuota struct {
+	version      int8
+	flags        int8
+	fieldmask    uint16
+	id           uint32
+	blkHardlimit uint64
+	blkSoftlimit uint64
+	inoHardlimit uint64
+	inoSoftlimit uint64
+	bcount       uint64
+	icount       uint64
+	itimer       int32
+	btimer       int32
+	iwarns       uint16
+	bwarns       uint16
+	padding2     int32
+	rtbHardlimit uint64
+	rtbSoftlimit uint64
+	rtbcount     uint64
+	rtbtimer     int32
+	rtbwarns     uint16
+	padding3     int16
+	padding4     [8]byte
+}
+
+// Parse the options of `run --storage-opt`, only size is supported
+func ParseStorageOpts(opts []string) (int64, error) {
+	var size int64
+	for _, opt := range opts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || strings.ToLower(kv[0]) != "size" {
+			return 0, fmt.Errorf("Unknown storage option %q, only size=<size> is supported", opt)
+		}
+		n, err := ParseSize(kv[1])
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return 0, fmt.Errorf("Invalid storage size %q", kv[1])
+		}
+		size = n
+	}
+	return size, nil
+}
+
+func writeLayerPath(containerName string) string {
+	return fmt.Sprintf(WriteLayerUrl, containerName)
+}
+
+func loopbackImagePath(containerName string) string {
+	return writeLayerPath(containerName) + ".img"
+}
+
+// Limit the write layer of a container to size bytes with a project quota,
+// or with a loopback filesystem if the host filesystem has no project
+// quotas. Return the method used.
+func setUpQuota(containerName string, size int64) (string, error) {
+	dir := writeLayerPath(containerName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	err := setProjectQuota(dir, size)
+	if err == nil {
+		log.Infof("Limit write layer %s to %d bytes with a project quota", dir, size)
+		return QuotaProject, nil
+	}
+	log.Infof("Project quota unavailable on %s (%v), use a loopback filesystem", dir, err)
+	if err := mountLoopback(containerName, size); err != nil {
+		return "", err
+	}
+	return QuotaLoopback, nil
+}
+
+func setProjectQuota(dir string, size int64) error {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return err
+	}
+	if stat.Type != xfsSuperMagic && stat.Type != ext4SuperMagic {
+		return fmt.Errorf("filesystem type %#x has no project quota", stat.Type)
+	}
+
+	// The device node and project IDs are shared by all write layers
+	base := path.Dir(dir)
+	lock, err := lockQuota(base)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	device, err := backingFsBlockDev(dir)
+	if err != nil {
+		return err
+	}
+	projectId, err := nextProjectId(base)
+	if err != nil {
+		return err
+	}
+
+	if err := setProjectLimit(device, projectId, uint64(size)>>quotaBlockShift); err != nil {
+		return fmt.Errorf("set quota of project %d: %v", projectId, err)
+	}
+	if err := setProjectId(dir, projectId); err != nil {
+		// No limit is left on a project no directory belongs to
+		setProjectLimit(device, projectId, 0)
+		return err
+	}
+	log.Infof("$ xfs_quota -x -c 'limit -p bhard=%d %d' %s", size, projectId, device)
+	return nil
+}
+
+// Clear the limit of the project of a write layer, or unmount and remove
+// its loopback filesystem
+func removeQuota(containerName string) {
+	dir := writeLayerPath(containerName)
+	if mounted, _ := misc.IsMounted(dir); mounted {
+		if err := syscall.Unmount(dir, syscall.MNT_DETACH); err != nil {
+			log.Errorf("Unmount %s error %v", dir, err)
+			return
+		}
+		log.Infof("$ umount %s", dir)
+	}
+	image := loopbackImagePath(containerName)
+	if exist, _ := PathExists(image); exist {
+		if err := os.Remove(image); err != nil {
+			log.Errorf("Remove %s error %v", image, err)
+		} else {
+			log.Infof("$ rm %s", image)
+		}
+		return
+	}
+
+	projectId, err := getProjectId(dir)
+	if err != nil || projectId == 0 {
+		return
+	}
+	lock, err := lockQuota(path.Dir(dir))
+	if err != nil {
+		log.Warnf("Lock quota of %s error %v", dir, err)
+		return
+	}
+	defer lock.Close()
+	device, err := backingFsBlockDev(dir)
+	if err != nil {
+		return
+	}
+	if err := setProjectLimit(device, projectId, 0); err != nil {
+		log.Warnf("Clear quota of project %d error %v", projectId, err)
+	}
+}
+
+// Limit the blocks of a project, 0 clears the limit
+func setProjectLimit(device string, projectId uint32, blocks uint64) error {
+	quota := fsDiskQuota{
+		version:      fsDquotVersion,
+		flags:        fsProjQuota,
+		fieldmask:    fsDqBHard | fsDqBSoft,
+		id:           projectId,
+		blkHardlimit: blocks,
+		blkSoftlimit: blocks,
+	}
+	return quotactl(qXSetQLim, device, projectId, unsafe.Pointer(&quota))
+}
+
+// quotactl(2) takes the block device of a filesystem. Create a device node
+// matching the filesystem of dir, so that it works inside any mount. The
+// node is shared by all write layers, callers hold the quota lock.
+func backingFsBlockDev(dir string) (string, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(dir, &stat); err != nil {
+		return "", err
+	}
+	device := path.Join(path.Dir(dir), ".backingFsBlockDev")
+	syscall.Unlink(device)
+	if err := syscall.Mknod(device, syscall.S_IFBLK|0600, int(stat.Dev)); err != nil {
+		return "", fmt.Errorf("mknod %s: %v", device, err)
+	}
+	return device, nil
+}
+
+func lockQuota(base string) (*os.File, error) {
+	file, err := os.OpenFile(path.Join(base, ".quota.lock"), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Return one more than the largest project ID of write layers under base
+func nextProjectId(base string) (uint32, error) {
+	dirs, err := filepath.Glob(path.Join(base, "*"))
+	if err != nil {
+		return 0, err
+	}
+	next := uint32(1)
+	for _, dir := range dirs {
+		if id, err := getProjectId(dir); err == nil && id >= next {
+			next = id + 1
+		}
+	}
+	return next, nil
+}
+
+func getProjectId(dir string) (uint32, error) {
+	attr, err := fsGetxattr(dir)
+	if err != nil {
+		return 0, err
+	}
+	return attr.projid, nil
+}
+
+func setProjectId(dir string, projectId uint32) error {
+	attr, err := fsGetxattr(dir)
+	if err != nil {
+		return err
+	}
+	attr.projid = projectId
+	attr.xflags |= fsXflagProjinherit
+	return fsIoctl(dir, fsIocFssetxattr, attr)
+}
+
+func fsGetxattr(dir string) (*fsxattr, error) {
+	var attr fsxattr
+	if err := fsIoctl(dir, fsIocFsgetxattr, &attr); err != nil {
+		return nil, err
+	}
+	return &attr, nil
+}
+
+func fsIoctl(dir string, request uintptr, attr *fsxattr) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), request, uintptr(unsafe.Pointer(attr)))
+	if errno != 0 {
+		return fmt.Errorf("ioctl %s: %v", dir, errno)
+	}
+	return nil
+}
+
+func quotactl(cmd int, device string, id uint32, addr unsafe.Pointer) error {
+	special, err := syscall.BytePtrFromString(device)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL, uintptr(cmd<<8|prjQuota),
+		uintptr(unsafe.Pointer(special)), uintptr(id), uintptr(addr), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Create a sparse ext4 image of size bytes and loop mount it on the write
+// layer directory of the container
+func mountLoopback(containerName string, size int64) error {
+	dir, image := writeLayerPath(containerName), loopbackImagePath(containerName)
+	file, err := os.OpenFile(image, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = file.Truncate(size)
+	file.Close()
+	if err != nil {
+		os.Remove(image)
+		return err
+	}
+	log.Infof("$ truncate -s %d %s", size, image)
+
+	output, err := exec.Command("mkfs.ext4", "-q", "-F", "-m", "0", image).CombinedOutput()
+	if err != nil {
+		os.Remove(image)
+		return fmt.Errorf("mkfs.ext4 %s error: %v %s", image, err, strings.TrimSpace(string(output)))
+	}
+	log.Infof("$ mkfs.ext4 -q -F -m 0 %s", image)
+	output, err = exec.Command("mount", "-o", "loop", image, dir).CombinedOutput()
+	if err != nil {
+		os.Remove(image)
+		return fmt.Errorf("Mount %s error: %v %s", image, err, strings.TrimSpace(string(output)))
+	}
+	log.Infof("$ mount -o loop %s %s", image, dir)
+	return nil
+}
+
+// Return the bytes of files in the write layer of a container
+func WriteLayerUsage(containerName string) int64 {
+	return archive.DiskUsage(diffPath(containerName))
+}
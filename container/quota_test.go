@@ -0,0 +1,30 @@
+package container
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestParseStorageOpts(t *testing.T) {
+	if size, err := ParseStorageOpts([]string{"size=2G"}); err != nil || size != 2<<30 {
+		t.Errorf("size=2G = %d, %v", size, err)
+	}
+	if size, err := ParseStorageOpts(nil); err != nil || size != 0 {
+		t.Errorf("no options = %d, %v", size, err)
+	}
+	for _, opt := range []string{"size", "size=0", "size=big", "inodes=100"} {
+		if _, err := ParseStorageOpts([]string{opt}); err == nil {
+			t.Errorf("invalid storage option %q accepted", opt)
+		}
+	}
+}
+
+// The structs are passed to the kernel, they must match the C layout
+func TestQuotaStructSizes(t *testing.T) {
+	if size := unsafe.Sizeof(fsxattr{}); size != 28 {
+		t.Errorf("sizeof(struct fsxattr) = %d", size)
+	}
+	if size := unsafe.Sizeof(fsDiskQuota{}); size != 112 {
+		t.Errorf("sizeof(struct fs_disk_quota) = %d", size)
+	}
+}
@@ -11,4 +11,7 @@ var (
 	ImageUrl            string = "./images"
 	MntUrl              string = "/root/mnt/%s"
 	WriteLayerUrl       string = "/root/writeLayer/%s"
+	// Storage driver used when `run` does not specify one, it can
+	// be changed globally by `mydocker --storage-driver aufs ...`
+	DefaultStorageDriver string = "overlay"
 )
@@ -0,0 +1,124 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Switch the init process to user, given as "user[:group]" where user and
+// group are names or numeric IDs. Names are looked up in /etc/passwd and
+// /etc/group of the container, so it must run after pivot_root.
+func switchUser(user string) error {
+	name, group := user, ""
+	if i := strings.Index(user, ":"); i >= 0 {
+		name, group = user[:i], user[i+1:]
+	}
+
+	uid, gid, err := lookupUser(name)
+	if err != nil {
+		return err
+	}
+	if group != "" {
+		if gid, err = lookupGroup(group); err != nil {
+			return err
+		}
+	}
+
+	// Drop supplementary groups inherited from the host
+	if err := syscall.Setgroups([]int{}); err != nil {
+		return fmt.Errorf("Set groups error %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("Set gid %d error %v", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("Set uid %d error %v", uid, err)
+	}
+	log.Infof("$ su %s (uid=%d gid=%d)", user, uid, gid)
+	return nil
+}
+
+// Return uid and primary gid of a user name or uid. A numeric uid missing
+// from /etc/passwd is allowed and gets gid 0.
+func lookupUser(name string) (int, int, error) {
+	uid, numeric := strconv.Atoi(name)
+	var found []string
+	err := scanIdFile("/etc/passwd", func(fields []string) bool {
+		// name:password:uid:gid:gecos:home:shell
+		if len(fields) < 4 {
+			return false
+		}
+		if fields[0] == name || (numeric == nil && fields[2] == name) {
+			found = fields
+			return true
+		}
+		return false
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return 0, 0, err
+	}
+	if found == nil {
+		if numeric == nil {
+			return uid, 0, nil
+		}
+		return 0, 0, fmt.Errorf("No such user: %s", name)
+	}
+	uid, err = strconv.Atoi(found[2])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid uid of user %s: %s", name, found[2])
+	}
+	gid, err := strconv.Atoi(found[3])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid gid of user %s: %s", name, found[3])
+	}
+	return uid, gid, nil
+}
+
+// Return gid of a group name or gid
+func lookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	gid := -1
+	err := scanIdFile("/etc/group", func(fields []string) bool {
+		// name:password:gid:members
+		if len(fields) >= 3 && fields[0] == group {
+			gid, _ = strconv.Atoi(fields[2])
+			return true
+		}
+		return false
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	if gid < 0 {
+		return 0, fmt.Errorf("No such group: %s", group)
+	}
+	return gid, nil
+}
+
+// Call match with colon separated fields of each line of path, until
+// match returns true
+func scanIdFile(path string, match func(fields []string) bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if match(strings.Split(line, ":")) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
@@ -1,190 +1,187 @@
 package container
 
 import (
+	"../archive"
+	"../image"
+	"../volume"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"os"
-	"os/exec"
-	"strings"
-	"syscall"
+	"path"
+	"path/filepath"
 )
 
-//Create a AUFS filesystem as container root workspace
-func NewWorkSpace(volume, imageName, containerName string) {
-	CreateReadOnlyLayer(imageName)
-	CreateWriteLayer(containerName)
-	CreateMountPoint(containerName, imageName)
-	if volume != "" {
-		volumeURLs := strings.Split(volume, ":")
-		length := len(volumeURLs)
-		if length == 2 && volumeURLs[0] != "" && volumeURLs[1] != "" {
-			MountVolume(volumeURLs, containerName)
-			log.Infof("NewWorkSpace volume urls %q", volumeURLs)
-		} else {
-			log.Infof("Volume parameter input is not correct.")
-		}
-	}
-}
-
-//Decompression tar image
-func CreateReadOnlyLayer(imageName string) error {
-	unTarFolderUrl := RootUrl + "/" + imageName + "/"
-	imageUrl := ImageUrl + "/" + imageName + ".tar"
-	exist, err := PathExists(unTarFolderUrl)
+// Create a union filesystem as container root workspace
+func NewWorkSpace(config *ContainerConfig) error {
+	containerName := config.Name
+	driver, err := GetStorageDriver(config.StorageDriver)
 	if err != nil {
-		log.Errorf("%v", err)
 		return err
 	}
-	if !exist {
-		if err := os.MkdirAll(unTarFolderUrl, 0622); err != nil {
-			log.Errorf("$ %v", err)
+	log.Infof("Use storage driver %s", driver.Name())
+
+	var lowerDirs []string
+	if config.Rootfs != "" {
+		if err := verifyRootfs(config.Rootfs, config.SkipVerify); err != nil {
 			return err
-		} else {
-			log.Infof("$ mkdir %s -m 0777", unTarFolderUrl)
 		}
-
-		if _, err := exec.Command("tar", "-xvf", imageUrl, "-C", unTarFolderUrl).CombinedOutput(); err != nil {
-			log.Errorf("%v", err)
+		lowerDirs = []string{config.Rootfs}
+	} else if lowerDirs, err = CreateReadOnlyLayer(config.ImageName, driver.Name(), config.SkipVerify); err != nil {
+		return err
+	}
+	// A read-only container has no write layer
+	if config.ReadOnly {
+		return driver.MountReadOnly(containerName, lowerDirs)
+	}
+	if config.StorageSize > 0 {
+		if config.StorageQuota, err = setUpQuota(containerName, config.StorageSize); err != nil {
 			return err
-		} else {
-			log.Infof("$ tar -xvf %s -C %s", imageUrl, unTarFolderUrl)
 		}
 	}
+	if err := driver.Prepare(containerName); err != nil {
+		rollBackQuota(config)
+		return err
+	}
+	if err := driver.Mount(containerName, lowerDirs); err != nil {
+		log.Errorf("Mount container %s rootfs error %v", containerName, err)
+		rollBackQuota(config)
+		return err
+	}
 	return nil
 }
 
-func CreateWriteLayer(containerName string) {
-	writeURL := fmt.Sprintf(WriteLayerUrl, containerName)
-	if err := os.MkdirAll(writeURL, 0777); err != nil {
-		log.Warnf("$ %v", err)
-	} else {
-		log.Infof("$ mkdir %s -m 0777", writeURL)
+// Remove the quota of a container whose workspace could not be set up
+func rollBackQuota(config *ContainerConfig) {
+	if config.StorageQuota != "" {
+		removeQuota(config.Name)
+		config.StorageQuota = ""
 	}
 }
 
-// Volumes provide the best and most predictable performance for write-heavy workloads.
-// This is because they bypass the storage driver and do not incur any of the potential
-// overheads introduced by thin provisioning and copy-on-write. Volumes have other
-// benefits, such as allowing you to share data among containers and persisting even
-// when no running container is using them.
-
-func MountVolume(volumeURLs []string, containerName string) error {
-	parentUrl := volumeURLs[0]
-	if err := os.Mkdir(parentUrl, 0777); err != nil {
-		log.Warnf("$ %v", err)
-	} else {
-		log.Infof("$ mkdir %s -m 0777", parentUrl)
+// Return read-only layer dirs of an image from the image store, top-most
+// layer first. The image must be allowed by the signature policy, unless
+// skipVerify is set.
+func CreateReadOnlyLayer(imageName, storageDriver string, skipVerify bool) ([]string, error) {
+	if err := EnsureImage(imageName); err != nil {
+		return nil, err
 	}
-
-	containerUrl := volumeURLs[1]
-	mntURL := fmt.Sprintf(MntUrl, containerName)
-	containerVolumeURL := mntURL + "/" + containerUrl
-	if err := os.Mkdir(containerVolumeURL, 0777); err != nil {
-		log.Warnf("%v", err)
-	} else {
-		log.Infof("$ mkdir %s -m 0777", containerVolumeURL)
+	if skipVerify {
+		log.Warnf("Skip signature verification of image %s", imageName)
+	} else if err := image.VerifyImage(imageName); err != nil {
+		return nil, err
 	}
+	return image.LayerDirs(imageName, storageDriver)
+}
 
-	dirs := "dirs=" + parentUrl
-	_, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", containerVolumeURL).CombinedOutput()
+// Return the absolute path of a rootfs directory given to `run --rootfs`.
+// An OCI runtime bundle, with config.json next to the rootfs directory,
+// may be given instead of its rootfs.
+func RootfsDir(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
 	if err != nil {
-		log.Errorf("%v", err)
-		return err
-	} else {
-		log.Infof("$ mount -t aufs -o %s none %s", dirs, containerVolumeURL)
-		log.Infof("AUFS: %s[rw] -> %s[aufs]", parentUrl, containerVolumeURL)
+		return "", err
 	}
-	return nil
-}
-
-func CreateMountPoint(containerName, imageName string) error {
-	mntUrl := fmt.Sprintf(MntUrl, containerName)
-	if err := os.MkdirAll(mntUrl, 0777); err != nil {
-		log.Warnf("%v", err)
-		return err
-	} else {
-		log.Infof("$ mkdir %s -m 0777", mntUrl)
+	bundleRootfs := path.Join(dir, "rootfs")
+	if exist, _ := PathExists(path.Join(dir, "config.json")); exist {
+		if info, err := os.Stat(bundleRootfs); err == nil && info.IsDir() {
+			dir = bundleRootfs
+		}
 	}
-
-	tmpWriteLayer := fmt.Sprintf(WriteLayerUrl, containerName)
-	tmpImageLocation := RootUrl + "/" + imageName
-	mntURL := fmt.Sprintf(MntUrl, containerName)
-	dirs := "dirs=" + tmpWriteLayer + ":" + tmpImageLocation
-	_, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", mntURL).CombinedOutput()
+	info, err := os.Stat(dir)
 	if err != nil {
-		log.Errorf("Run command for creating mount point failed %v", err)
-		return err
-	} else {
-		log.Infof("$ mount -t aufs -o %s none %s", dirs, mntURL)
-		log.Infof("AUFS: %s[rw], %s[ro] -> %s[aufs]", tmpWriteLayer, tmpImageLocation, mntURL)
+		return "", err
 	}
-
-	return nil
+	if !info.IsDir() {
+		return "", fmt.Errorf("Rootfs %s is not a directory", dir)
+	}
+	return dir, nil
 }
 
-//Delete the AUFS filesystem while container exit
-func DeleteWorkSpace(volume, containerName string) {
-	if volume != "" {
-		volumeURLs := strings.Split(volume, ":")
-		length := len(volumeURLs)
-		if length == 2 && volumeURLs[0] != "" && volumeURLs[1] != "" {
-			DeleteMountPointWithVolume(volumeURLs, containerName)
-		} else {
-			DeleteMountPoint(containerName)
-		}
-	} else {
-		DeleteMountPoint(containerName)
+// A rootfs directory carries no signature, it only runs if the default
+// requirement of the signature policy accepts anything
+func verifyRootfs(dir string, skipVerify bool) error {
+	if skipVerify {
+		log.Warnf("Skip signature policy check of rootfs %s", dir)
+		return nil
+	}
+	policy, err := image.LoadPolicy()
+	if err != nil || policy == nil {
+		return err
+	}
+	if policy.Default.Type != image.PolicyAcceptAnything {
+		return fmt.Errorf("Rootfs %s rejected by policy, it requires signed images", dir)
 	}
-	DeleteWriteLayer(containerName)
+	return nil
 }
 
-func DeleteMountPoint(containerName string) error {
-	mntURL := fmt.Sprintf(MntUrl, containerName)
-	if err := syscall.Unmount(mntURL, syscall.MNT_FORCE); err != nil {
-		log.Errorf("%v", err)
-	} else {
-		log.Infof("$ umount %s", mntURL)
+// Make sure an image is in the image store. A plain tarball
+// "<ImageUrl>/<image>.tar" is imported into the store as a single-layer
+// image the first time it is used.
+func EnsureImage(imageName string) error {
+	_, err := image.Resolve(imageName)
+	if err == nil {
+		return nil
+	}
+	name, _ := image.ParseReference(imageName)
+	imageTar := ImageUrl + "/" + name + ".tar"
+	exist, _ := PathExists(imageTar)
+	if !exist {
+		return err
 	}
-
-	if err := os.RemoveAll(mntURL); err != nil {
-		log.Errorf("%v", err)
+	log.Infof("Import %s into image store", imageTar)
+	if _, err := image.Import(imageTar, imageName, nil); err != nil {
+		log.Errorf("Import %s error %v", imageTar, err)
 		return err
-	} else {
-		log.Infof("$ rm -rf %s", mntURL)
 	}
-
 	return nil
 }
 
-func DeleteMountPointWithVolume(volumeURLs []string, containerName string) error {
-	mntURL := fmt.Sprintf(MntUrl, containerName)
-	containerUrl := mntURL + "/" + volumeURLs[1]
-	if err := syscall.Unmount(containerUrl, syscall.MNT_DETACH); err != nil {
-		log.Errorf("%v", err)
-	} else {
-		log.Infof("$ umount %s", containerUrl)
+// Marks "<RootUrl>/<image>" as a rootfs unpacked by mydocker from
+// "<ImageUrl>/<image>.tar", before images were kept in the image store.
+// Directories without it are never removed by PruneLegacyImages; rootfs
+// unpacked by releases that wrote no marker are left to the administrator,
+// who may create it to have them pruned.
+const LegacyRootfsMarker = ".mydocker-rootfs"
+
+// Remove the legacy rootfs directories of RootUrl, unless a container in
+// inUse was created from the image, and return the bytes freed
+func PruneLegacyImages(inUse map[string]bool) (int64, error) {
+	markers, err := filepath.Glob(path.Join(RootUrl, "*", LegacyRootfsMarker))
+	if err != nil {
+		return 0, err
+	}
+	var reclaimed int64
+	for _, marker := range markers {
+		dir := path.Dir(marker)
+		if inUse[path.Base(dir)] || dir == path.Clean(image.StoreUrl) || dir == path.Clean(volume.VolumeUrl) ||
+			dir == path.Dir(MntUrl) || dir == path.Dir(WriteLayerUrl) {
+			continue
+		}
+		if info, err := os.Lstat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		reclaimed += archive.DiskUsage(dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return reclaimed, err
+		}
+		log.Infof("$ rm -rf %s", dir)
 	}
+	return reclaimed, nil
+}
 
-	if err := syscall.Unmount(mntURL, syscall.MNT_DETACH); err != nil {
+// Delete the union filesystem while container exit
+func DeleteWorkSpace(mounts []Mount, containerName, storageDriver string) {
+	driver, err := GetStorageDriver(storageDriver)
+	if err != nil {
 		log.Errorf("%v", err)
-	} else {
-		log.Infof("$ umount %s", mntURL)
+		return
 	}
-
-	if err := os.RemoveAll(mntURL); err != nil {
+	unmountAll(containerName, mounts)
+	if err := driver.Unmount(containerName); err != nil {
 		log.Errorf("%v", err)
-	} else {
-		log.Infof("$ rm -rf %s", mntURL)
 	}
-
-	return nil
-}
-
-func DeleteWriteLayer(containerName string) {
-	writeURL := fmt.Sprintf(WriteLayerUrl, containerName)
-	if err := os.RemoveAll(writeURL); err != nil {
-		log.Errorf("%v", err)
-	} else {
-		log.Infof("$ rm -rf %s", writeURL)
+	removeQuota(containerName)
+	if err := driver.Cleanup(containerName); err != nil {
+		log.Errorf("Cleanup container %s workspace error %v", containerName, err)
 	}
 }
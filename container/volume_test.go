@@ -0,0 +1,100 @@
+package container
+
+import (
+	"../image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPruneLegacyImages(t *testing.T) {
+	root, err := ioutil.TempDir("", "mydocker-root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer func(rootUrl string) { RootUrl = rootUrl }(RootUrl)
+	RootUrl = root
+
+	// Only rootfs marked as unpacked by mydocker are removed
+	for _, name := range []string{"busybox", "alpine", "home"} {
+		os.MkdirAll(filepath.Join(root, name, "bin"), 0755)
+		ioutil.WriteFile(filepath.Join(root, name, "bin", "sh"), []byte("#!"), 0755)
+	}
+	for _, name := range []string{"busybox", "alpine"} {
+		ioutil.WriteFile(filepath.Join(root, name, LegacyRootfsMarker), nil, 0644)
+	}
+
+	reclaimed, err := PruneLegacyImages(map[string]bool{"alpine": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed != 2 {
+		t.Errorf("reclaimed %d bytes", reclaimed)
+	}
+	for name, kept := range map[string]bool{"busybox": false, "alpine": true, "home": true} {
+		if _, err := os.Stat(filepath.Join(root, name)); (err == nil) != kept {
+			t.Errorf("%s kept = %v", name, err == nil)
+		}
+	}
+}
+
+func TestRootfsDir(t *testing.T) {
+	root, err := ioutil.TempDir("", "mydocker-rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	// An OCI runtime bundle runs its rootfs directory
+	bundle := filepath.Join(root, "bundle")
+	os.MkdirAll(filepath.Join(bundle, "rootfs", "bin"), 0755)
+	ioutil.WriteFile(filepath.Join(bundle, "config.json"), []byte("{}"), 0644)
+	if dir, err := RootfsDir(bundle); err != nil || dir != filepath.Join(bundle, "rootfs") {
+		t.Errorf("RootfsDir(bundle) = %q, %v", dir, err)
+	}
+
+	plain := filepath.Join(root, "plain")
+	os.MkdirAll(filepath.Join(plain, "rootfs"), 0755)
+	if dir, err := RootfsDir(plain); err != nil || dir != plain {
+		t.Errorf("RootfsDir(plain) = %q, %v", dir, err)
+	}
+
+	file := filepath.Join(root, "rootfs.tar")
+	ioutil.WriteFile(file, nil, 0644)
+	for _, dir := range []string{file, filepath.Join(root, "missing")} {
+		if _, err := RootfsDir(dir); err == nil {
+			t.Errorf("RootfsDir(%q) accepted", dir)
+		}
+	}
+}
+
+func TestVerifyRootfs(t *testing.T) {
+	root, err := ioutil.TempDir("", "mydocker-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer func(policyPath string) { image.PolicyPath = policyPath }(image.PolicyPath)
+	image.PolicyPath = filepath.Join(root, "policy.json")
+
+	// Without policy every rootfs runs
+	if err := verifyRootfs(root, false); err != nil {
+		t.Errorf("verify without policy %v", err)
+	}
+	ioutil.WriteFile(image.PolicyPath, []byte(`{"default": {"type": "insecureAcceptAnything"}}`), 0644)
+	if err := verifyRootfs(root, false); err != nil {
+		t.Errorf("verify with insecureAcceptAnything %v", err)
+	}
+
+	for _, policy := range []string{`{"default": {"type": "reject"}}`, `{"default": {"type": "signedBy", "keyPaths": ["/key.pub"]}}`} {
+		ioutil.WriteFile(image.PolicyPath, []byte(policy), 0644)
+		if err := verifyRootfs(root, false); err == nil {
+			t.Errorf("rootfs accepted by policy %s", policy)
+		}
+		if err := verifyRootfs(root, true); err != nil {
+			t.Errorf("skipped verification %v", err)
+		}
+	}
+}
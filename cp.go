@@ -0,0 +1,185 @@
+package main
+
+import (
+	"./archive"
+	"./container"
+	"./misc"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Copy files between a container and the host, like `docker cp`. One of
+// src and dst is "<container>:<path>", the other a host path, or "-" for
+// a tarball on stdin or stdout. Paths in the container are resolved in
+// its mount namespace, through /proc/<pid>/root, so files in volumes are
+// copied too.
+func copyFiles(src, dst string) error {
+	srcContainer, srcPath := splitCopyArg(src)
+	dstContainer, dstPath := splitCopyArg(dst)
+	if (srcContainer == "") == (dstContainer == "") {
+		return fmt.Errorf("Copy between a container and the host, one of %s and %s must be <container>:<path>", src, dst)
+	}
+
+	if srcContainer != "" {
+		root, err := containerRoot(srcContainer)
+		if err != nil {
+			return err
+		}
+		resolved, err := resolveInContainer(root, srcPath)
+		if err != nil {
+			return err
+		}
+		if dstPath == "-" {
+			// Keep logs out of the archive stream
+			log.SetOutput(os.Stderr)
+			info, err := os.Lstat(resolved)
+			if err != nil {
+				return err
+			}
+			return archive.Tar(os.Stdout, resolved, copyEntryName(srcPath, info.IsDir()))
+		}
+		return copyPath(resolved, srcPath, dstPath, func(p string) (string, error) {
+			return filepath.Abs(p)
+		})
+	}
+
+	root, err := containerRoot(dstContainer)
+	if err != nil {
+		return err
+	}
+	// The destination is followed to the end, a symlink in the container
+	// must not be followed on the host
+	resolve := func(p string) (string, error) {
+		return archive.ResolveInRoot(root, p)
+	}
+	if srcPath == "-" {
+		dir, err := resolve(dstPath)
+		if err != nil {
+			return err
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("Destination %s must be an existing directory to extract a tarball", dst)
+		}
+		return archive.Untar(os.Stdin, dir)
+	}
+	source, err := filepath.Abs(srcPath)
+	if err != nil {
+		return err
+	}
+	return copyPath(source, srcPath, dstPath, resolve)
+}
+
+// Split "<container>:<path>", a host path like ./a:b or /a:b has no
+// container part
+func splitCopyArg(arg string) (string, string) {
+	i := strings.Index(arg, ":")
+	if i <= 0 || strings.ContainsRune(arg[:i], '/') {
+		return "", arg
+	}
+	return arg[:i], arg[i+1:]
+}
+
+// Return the directory through which the filesystem of a container, as
+// seen by its processes, is reachable from the host. The rootfs of a
+// stopped container is still mounted until it is removed, without its
+// mounts.
+func containerRoot(containerName string) (string, error) {
+	info, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return "", fmt.Errorf("No Such Container: %s", containerName)
+	}
+	if info.Status == container.RUNNING {
+		procRoot := fmt.Sprintf("/proc/%s/root", strings.TrimSpace(info.Pid))
+		if _, err := os.Stat(procRoot); err == nil {
+			return procRoot, nil
+		}
+	}
+	driver, err := container.GetStorageDriver(info.StorageDriver)
+	if err != nil {
+		return "", err
+	}
+	if mounted, _ := misc.IsMounted(driver.Path(containerName)); !mounted {
+		return "", fmt.Errorf("Rootfs of container %s is not mounted", containerName)
+	}
+	return driver.Path(containerName), nil
+}
+
+// Resolve a path of the container under root. Symlinks of its parents are
+// followed inside the container, the last element is taken as it is.
+func resolveInContainer(root, p string) (string, error) {
+	clean := path.Clean("/" + p)
+	if clean == "/" {
+		return root, nil
+	}
+	parent, err := archive.ResolveInRoot(root, path.Dir(clean))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, path.Base(clean)), nil
+}
+
+// Copy source, the resolved path of srcArg, to dstArg resolved by resolve.
+// Like cp -a: a directory destination receives the source under its base
+// name, otherwise the destination is created or overwritten. A source
+// ending with "/." copies the content of the directory.
+func copyPath(source, srcArg, dstArg string, resolve func(string) (string, error)) error {
+	srcInfo, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	dest, err := resolve(dstArg)
+	if err != nil {
+		return err
+	}
+	name := copyEntryName(srcArg, srcInfo.IsDir())
+
+	destInfo, err := os.Stat(dest)
+	switch {
+	case err == nil && destInfo.IsDir():
+	case err == nil:
+		if srcInfo.IsDir() {
+			return fmt.Errorf("Cannot copy directory %s onto file %s", srcArg, dstArg)
+		}
+		dest, name = filepath.Dir(dest), filepath.Base(dest)
+	case os.IsNotExist(err):
+		if strings.HasSuffix(dstArg, "/") {
+			return fmt.Errorf("Destination directory %s does not exist", dstArg)
+		}
+		if srcInfo.IsDir() {
+			if err := os.Mkdir(dest, srcInfo.Mode().Perm()); err != nil {
+				return err
+			}
+			name = ""
+		} else {
+			dest, name = filepath.Dir(dest), filepath.Base(dest)
+		}
+	default:
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(archive.Tar(writer, source, name))
+	}()
+	err = archive.Untar(reader, dest)
+	reader.Close()
+	if err != nil {
+		return fmt.Errorf("Copy %s to %s error: %v", srcArg, dstArg, err)
+	}
+	log.Infof("$ cp -a %s %s", source, dest)
+	return nil
+}
+
+// Return the name of the top-level entry copied from srcArg, empty to copy
+// the content of a directory given as "dir/."
+func copyEntryName(srcArg string, isDir bool) string {
+	if isDir && (srcArg == "." || strings.HasSuffix(srcArg, "/.")) {
+		return ""
+	}
+	return path.Base(path.Clean("/" + srcArg))
+}
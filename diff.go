@@ -0,0 +1,49 @@
+package main
+
+import (
+	"./container"
+	"./image"
+	"encoding/json"
+	"fmt"
+)
+
+// Print files added (A), changed (C) and deleted (D) by a container
+func diffContainer(containerName string, jsonOutput bool) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("Get container %s info error %v", containerName, err)
+	}
+	driver, err := container.GetStorageDriver(containerInfo.StorageDriver)
+	if err != nil {
+		return err
+	}
+	// A read-only container has no write layer, hence no changes
+	var changes []image.Change
+	if !containerInfo.ReadOnly {
+		lowerDirs := []string{containerInfo.Rootfs}
+		if containerInfo.Rootfs == "" {
+			parent := containerImageID(containerInfo.ImageId, containerInfo.ImageName)
+			if lowerDirs, err = image.LayerDirs(parent, driver.Name()); err != nil {
+				return err
+			}
+		}
+		if changes, err = image.Changes(driver.DiffPath(containerName), lowerDirs); err != nil {
+			return err
+		}
+	}
+	if jsonOutput {
+		if changes == nil {
+			changes = []image.Change{}
+		}
+		content, err := json.MarshalIndent(changes, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+		return nil
+	}
+	for _, change := range changes {
+		fmt.Printf("%s %s\n", change.Kind, change.Path)
+	}
+	return nil
+}
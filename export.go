@@ -0,0 +1,68 @@
+package main
+
+import (
+	"./container"
+	"./image"
+	"./misc"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"strings"
+)
+
+// Write the merged rootfs of a container as a flat tarball, volumes
+// are not part of the container filesystem and are skipped
+func exportContainer(containerName, output string) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("Get container %s info error %v", containerName, err)
+	}
+	driver, err := container.GetStorageDriver(containerInfo.StorageDriver)
+	if err != nil {
+		return err
+	}
+	mntURL := driver.Path(containerName)
+	if mounted, _ := misc.IsMounted(mntURL); !mounted {
+		return fmt.Errorf("Rootfs of container %s is not mounted", containerName)
+	}
+
+	var excludes []string
+	if volumeURLs := strings.Split(containerInfo.Volume, ":"); len(volumeURLs) == 2 {
+		excludes = append(excludes, volumeURLs[1])
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	} else {
+		// Keep logs out of the archive stream
+		log.SetOutput(os.Stderr)
+	}
+	if err := image.TarDir(mntURL, w, excludes); err != nil {
+		return fmt.Errorf("Export container %s error %v", containerName, err)
+	}
+	log.Infof("$ tar -cf %s -C %s .", output, mntURL)
+	return nil
+}
+
+// Create a single-layer image from a rootfs tarball or directory
+func importImage(src, ref, cmd string, envSlice []string) error {
+	config := &image.ImageConfig{
+		Env: envSlice,
+	}
+	if cmd != "" {
+		config.Cmd = strings.Split(cmd, " ")
+	}
+	id, err := image.Import(src, ref, config)
+	if err != nil {
+		return err
+	}
+	fmt.Println(id)
+	return nil
+}
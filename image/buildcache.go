@@ -0,0 +1,114 @@
+package image
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// Every step of a build produces an image, which is recorded in the build
+// cache under a key derived from the parent image, the instruction and its
+// inputs. A later build with the same key reuses the image instead of
+// running the step again. Cached images without tags are intermediate
+// images, they are hidden from the image list by default.
+func buildCachePath() string {
+	return path.Join(StoreUrl, "buildcache.json")
+}
+
+func loadBuildCache() (map[string]string, error) {
+	cache := map[string]string{}
+	content, err := ioutil.ReadFile(buildCachePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cache, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &cache); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// Return the image cached under key, if it is still in the store
+func LookupBuildCache(key string) (string, bool) {
+	cache, err := loadBuildCache()
+	if err != nil {
+		return "", false
+	}
+	id, ok := cache[key]
+	if !ok {
+		return "", false
+	}
+	if exist, _ := pathExists(path.Join(imagedbDir(), digestHex(id))); !exist {
+		return "", false
+	}
+	return id, true
+}
+
+func RecordBuildCache(key, id string) error {
+	if err := os.MkdirAll(StoreUrl, 0755); err != nil {
+		return err
+	}
+	lock, err := lockFile(buildCachePath() + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	cache, err := loadBuildCache()
+	if err != nil {
+		return err
+	}
+	cache[key] = id
+	return dumpBuildCache(cache)
+}
+
+func dumpBuildCache(cache map[string]string) error {
+	content, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	tmpPath := buildCachePath() + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, buildCachePath())
+}
+
+// Return IDs of images produced by build steps
+func intermediateImages() (map[string]bool, error) {
+	cache, err := loadBuildCache()
+	if err != nil {
+		return nil, err
+	}
+	ids := map[string]bool{}
+	for _, id := range cache {
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// Drop cache entries of images which are no longer in the store
+func pruneBuildCache() error {
+	if exist, err := pathExists(buildCachePath()); err != nil || !exist {
+		return err
+	}
+	lock, err := lockFile(buildCachePath() + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	cache, err := loadBuildCache()
+	if err != nil {
+		return err
+	}
+	for key, id := range cache {
+		if exist, _ := pathExists(path.Join(imagedbDir(), digestHex(id))); !exist {
+			delete(cache, key)
+		}
+	}
+	return dumpBuildCache(cache)
+}
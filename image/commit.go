@@ -0,0 +1,34 @@
+package image
+
+import (
+	"fmt"
+)
+
+type CommitOptions struct {
+	Author    string
+	Comment   string
+	CreatedBy string // Command of the container, recorded in the history
+}
+
+// Create an image by stacking the write layer of a container, kept in
+// diffDir, on top of its parent image. It returns the new image ID.
+func Commit(parent, diffDir, ref string, options *CommitOptions) (string, error) {
+	_, manifest, img, err := GetImage(parent)
+	if err != nil {
+		return "", err
+	}
+	layers, err := ImageLayers(manifest, img)
+	if err != nil {
+		return "", fmt.Errorf("Image %s: %v", parent, err)
+	}
+	layer, err := WriteLayerDir(diffDir, nil)
+	if err != nil {
+		return "", err
+	}
+	layers = append(layers, layer)
+
+	img.Author = options.Author
+	img.Comment = options.Comment
+	img.AddHistory(options.CreatedBy, options.Comment, false)
+	return CreateImage(ref, img, layers)
+}
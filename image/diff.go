@@ -0,0 +1,315 @@
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Kinds of changes made by a container relative to its image
+const (
+	ChangeAdd    = "A"
+	ChangeModify = "C"
+	ChangeDelete = "D"
+)
+
+type Change struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+}
+
+// Compare the write layer of a container in diffDir with the read-only
+// layers of its image (top-most first), and return changes sorted by path
+func Changes(diffDir string, lowerDirs []string) ([]Change, error) {
+	var changes []Change
+	err := filepath.Walk(diffDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(diffDir, path)
+		if err != nil || name == "." {
+			return err
+		}
+		if isAufsMeta(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.Name() == WhiteoutOpaque {
+			// Entries of lower layers are hidden by an opaque directory
+			deleted, err := lowerEntries(lowerDirs, filepath.Dir(name))
+			if err != nil {
+				return err
+			}
+			for _, entry := range deleted {
+				if exist, _ := pathExists(filepath.Join(filepath.Dir(path), entry)); !exist {
+					changes = append(changes, Change{"/" + filepath.Join(filepath.Dir(name), entry), ChangeDelete})
+				}
+			}
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), WhiteoutPrefix) {
+			deleted := filepath.Join(filepath.Dir(name), strings.TrimPrefix(info.Name(), WhiteoutPrefix))
+			changes = append(changes, Change{"/" + deleted, ChangeDelete})
+			return nil
+		}
+		if isOverlayWhiteout(info) {
+			changes = append(changes, Change{"/" + name, ChangeDelete})
+			return nil
+		}
+
+		kind := ChangeAdd
+		if existInLower(lowerDirs, name) {
+			kind = ChangeModify
+		}
+		changes = append(changes, Change{"/" + name, kind})
+
+		if info.IsDir() && isOverlayOpaque(path) {
+			deleted, err := lowerEntries(lowerDirs, name)
+			if err != nil {
+				return err
+			}
+			for _, entry := range deleted {
+				if exist, _ := pathExists(filepath.Join(path, entry)); !exist {
+					changes = append(changes, Change{"/" + filepath.Join(name, entry), ChangeDelete})
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// Return whether name is visible in the union of lower layers. The
+// top-most layer holding either the file or a whiteout for it decides,
+// or a layer hiding a parent directory of name.
+func existInLower(lowerDirs []string, name string) bool {
+	for _, dir := range lowerDirs {
+		whiteout := filepath.Join(dir, filepath.Dir(name), WhiteoutPrefix+filepath.Base(name))
+		if exist, _ := pathExists(whiteout); exist {
+			return false
+		}
+		info, err := os.Lstat(filepath.Join(dir, name))
+		if err == nil {
+			return !isOverlayWhiteout(info)
+		}
+		if hidesParent(dir, name) {
+			return false
+		}
+	}
+	return false
+}
+
+// Return whether layer dir hides a parent directory of name from the
+// layers below: by a whiteout, by a file replacing it, or by making it
+// opaque with .wh..wh..opq or the overlay opaque xattr
+func hidesParent(dir, name string) bool {
+	for parent := filepath.Dir(name); parent != "."; parent = filepath.Dir(parent) {
+		whiteout := filepath.Join(dir, filepath.Dir(parent), WhiteoutPrefix+filepath.Base(parent))
+		if exist, _ := pathExists(whiteout); exist {
+			return true
+		}
+		parentPath := filepath.Join(dir, parent)
+		info, err := os.Lstat(parentPath)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() || isOverlayOpaque(parentPath) {
+			return true
+		}
+		if exist, _ := pathExists(filepath.Join(parentPath, WhiteoutOpaque)); exist {
+			return true
+		}
+	}
+	return false
+}
+
+// Return names of entries visible in directory name of the lower layers
+func lowerEntries(lowerDirs []string, name string) ([]string, error) {
+	seen := map[string]bool{}
+	var entries []string
+	for _, dir := range lowerDirs {
+		files, err := ioutil.ReadDir(filepath.Join(dir, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, file := range files {
+			if seen[file.Name()] || strings.HasPrefix(file.Name(), WhiteoutPrefix) {
+				continue
+			}
+			seen[file.Name()] = true
+			if existInLower(lowerDirs, filepath.Join(name, file.Name())) {
+				entries = append(entries, file.Name())
+			}
+		}
+	}
+	return entries, nil
+}
+
+// Write the content of dir as a layer tarball into w, paths listed in
+// excludes (relative to dir) are skipped. Overlayfs whiteouts (0/0
+// character devices and opaque directories) of a write layer are converted
+// into whiteout files, AUFS whiteouts are kept as they are and AUFS
+// internal files are skipped.
+func TarDir(dir string, w io.Writer, excludes []string) error {
+	tw := tar.NewWriter(w)
+	// Hard links are recorded once, later names link to the first one
+	inodes := map[uint64]string{}
+	excluded := map[string]bool{}
+	for _, exclude := range excludes {
+		excluded[filepath.Clean(strings.TrimPrefix(exclude, "/"))] = true
+	}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil || name == "." {
+			return err
+		}
+		if excluded[name] {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isAufsMeta(info.Name()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if isOverlayWhiteout(info) {
+			whiteout := filepath.Join(filepath.Dir(name), WhiteoutPrefix+info.Name())
+			return tw.WriteHeader(&tar.Header{Name: whiteout, Mode: 0600, Typeflag: tar.TypeReg})
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			hdr.Uid, hdr.Gid = int(stat.Uid), int(stat.Gid)
+			hdr.Uname, hdr.Gname = "", ""
+			if info.Mode().IsRegular() && stat.Nlink > 1 {
+				if first, ok := inodes[stat.Ino]; ok {
+					hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+				} else {
+					inodes[stat.Ino] = name
+				}
+			}
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			if err := addXattrs(hdr, path); err != nil {
+				return fmt.Errorf("Read xattrs of %s error: %v", path, err)
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if info.IsDir() && isOverlayOpaque(path) {
+			opaque := filepath.Join(name, WhiteoutOpaque)
+			return tw.WriteHeader(&tar.Header{Name: opaque, Mode: 0600, Typeflag: tar.TypeReg})
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, err := io.Copy(tw, file); err != nil {
+			return fmt.Errorf("Archive %s error: %v", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// Record extended attributes of path as PAX records of hdr. Overlayfs
+// private attributes describe the write layer and are left out.
+func addXattrs(hdr *tar.Header, path string) error {
+	size, err := syscall.Listxattr(path, nil)
+	if err != nil || size == 0 {
+		if err == syscall.ENOTSUP {
+			return nil
+		}
+		return err
+	}
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(path, buf); err != nil {
+		return err
+	}
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if name == "" || strings.HasPrefix(name, "trusted.overlay.") {
+			continue
+		}
+		n, err := syscall.Getxattr(path, name, nil)
+		if err != nil {
+			return err
+		}
+		value := make([]byte, n)
+		if n, err = syscall.Getxattr(path, name, value); err != nil {
+			return err
+		}
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		hdr.PAXRecords["SCHILY.xattr."+name] = string(value[:n])
+	}
+	return nil
+}
+
+// AUFS keeps its own bookkeeping under .wh..wh.* entries, only the
+// opaque marker belongs to the layer
+func isAufsMeta(name string) bool {
+	return strings.HasPrefix(name, WhiteoutPrefix+WhiteoutPrefix) && name != WhiteoutOpaque
+}
+
+func isOverlayWhiteout(info os.FileInfo) bool {
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Rdev == 0
+}
+
+func isOverlayOpaque(path string) bool {
+	value := make([]byte, 1)
+	n, err := syscall.Getxattr(path, overlayOpaque, value)
+	return err == nil && n == 1 && value[0] == 'y'
+}
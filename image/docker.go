@@ -0,0 +1,219 @@
+package image
+
+import (
+	"../archive"
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// An image archive written by `docker save` looks like
+//
+// <archive>
+// |-- manifest.json              [{"Config": "<hex>.json", "RepoTags": [...], "Layers": [...]}]
+// |-- repositories               {"busybox": {"latest": "<top layer id>"}}
+// |-- <hex>.json                 image config
+// `-- <layer id>/layer.tar       uncompressed layer, next to VERSION and json
+//
+// Only manifest.json is needed to load an archive, repositories and the
+// per-layer json are written for older tools.
+const (
+	dockerManifestFile     = "manifest.json"
+	dockerRepositoriesFile = "repositories"
+	dockerLayerVersion     = "1.0"
+)
+
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+type dockerLayerJSON struct {
+	ID     string `json:"id"`
+	Parent string `json:"parent,omitempty"`
+}
+
+// Load images from a `docker save` archive into the store. It returns
+// the loaded references, or image IDs for untagged images.
+func LoadDockerArchive(r io.Reader) ([]string, error) {
+	tmpDir, err := ioutil.TempDir("", "mydocker-load-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := archive.Untar(r, tmpDir); err != nil {
+		return nil, fmt.Errorf("Unpack image archive error: %v", err)
+	}
+
+	var manifests []dockerManifest
+	manifestFile, err := archivePath(tmpDir, dockerManifestFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := readJSONFile(manifestFile, &manifests); err != nil {
+		return nil, fmt.Errorf("Read %s error: %v", dockerManifestFile, err)
+	}
+
+	var loaded []string
+	for _, entry := range manifests {
+		id, err := loadDockerImage(tmpDir, entry)
+		if err != nil {
+			return nil, err
+		}
+		if len(entry.RepoTags) == 0 {
+			loaded = append(loaded, id)
+		}
+		for _, tag := range entry.RepoTags {
+			if err := Tag(id, tag); err != nil {
+				return nil, err
+			}
+			loaded = append(loaded, NormalizeReference(tag))
+		}
+	}
+	return loaded, nil
+}
+
+func loadDockerImage(dir string, entry dockerManifest) (string, error) {
+	configPath, err := archivePath(dir, entry.Config)
+	if err != nil {
+		return "", err
+	}
+	configFile, err := os.Open(configPath)
+	if err != nil {
+		return "", err
+	}
+	defer configFile.Close()
+	configDesc, err := WriteBlob(configFile, MediaTypeImageConfig)
+	if err != nil {
+		return "", err
+	}
+	var img Image
+	if err := readJSONBlob(configDesc.Digest, &img); err != nil {
+		return "", fmt.Errorf("Read image config %s error: %v", entry.Config, err)
+	}
+	if len(img.RootFS.DiffIDs) != len(entry.Layers) {
+		return "", fmt.Errorf("Image config %s lists %d layers, archive has %d",
+			entry.Config, len(img.RootFS.DiffIDs), len(entry.Layers))
+	}
+
+	// Layers are applied in order, the first one is the base layer
+	var layers []*Layer
+	for i, name := range entry.Layers {
+		layerPath, err := archivePath(dir, name)
+		if err != nil {
+			return "", err
+		}
+		layer, err := WriteLayerFile(layerPath)
+		if err != nil {
+			return "", err
+		}
+		if layer.DiffID != img.RootFS.DiffIDs[i] {
+			return "", fmt.Errorf("Layer %s has diff ID %s, expected %s",
+				name, layer.DiffID, img.RootFS.DiffIDs[i])
+		}
+		layers = append(layers, layer)
+	}
+	return createImageFromConfig("", configDesc, layers)
+}
+
+// Write images referenced by refs into w as a `docker save` archive
+func SaveDockerArchive(refs []string, w io.Writer) error {
+	repositories, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+
+	writer := &tarLayoutWriter{tw: tar.NewWriter(w)}
+	written := map[string]bool{}
+	var manifests []dockerManifest
+	dockerRepositories := map[string]map[string]string{}
+	for _, ref := range refs {
+		_, manifest, img, err := GetImage(ref)
+		if err != nil {
+			return err
+		}
+		if len(img.RootFS.DiffIDs) != len(manifest.Layers) {
+			return fmt.Errorf("Image %s config lists %d layers, manifest has %d",
+				ref, len(img.RootFS.DiffIDs), len(manifest.Layers))
+		}
+
+		entry := dockerManifest{
+			Config: digestHex(manifest.Config.Digest) + ".json",
+		}
+		if !written[entry.Config] {
+			if err := exportBlobAs(writer, manifest.Config.Digest, entry.Config); err != nil {
+				return err
+			}
+			written[entry.Config] = true
+		}
+
+		parent := ""
+		for i, layer := range manifest.Layers {
+			layerID := digestHex(img.RootFS.DiffIDs[i])
+			layerName := layerID + "/layer.tar"
+			if !written[layerName] {
+				if err := saveDockerLayer(writer, layer, layerID, parent); err != nil {
+					return err
+				}
+				written[layerName] = true
+			}
+			entry.Layers = append(entry.Layers, layerName)
+			parent = layerID
+		}
+
+		if _, ok := repositories[NormalizeReference(ref)]; ok {
+			name, tag := ParseReference(ref)
+			entry.RepoTags = []string{name + ":" + tag}
+			if dockerRepositories[name] == nil {
+				dockerRepositories[name] = map[string]string{}
+			}
+			dockerRepositories[name][tag] = parent
+		}
+		manifests = append(manifests, entry)
+		log.Infof("Save image %s with %d layers", ref, len(manifest.Layers))
+	}
+
+	if err := writeJSONFile(writer, dockerManifestFile, manifests); err != nil {
+		return err
+	}
+	if len(dockerRepositories) > 0 {
+		if err := writeJSONFile(writer, dockerRepositoriesFile, dockerRepositories); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func saveDockerLayer(writer layoutWriter, layer Descriptor, layerID, parent string) error {
+	layerTar, err := OpenLayerTar(layer.Digest)
+	if err != nil {
+		return err
+	}
+	defer layerTar.Close()
+	info, err := layerTar.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := writer.WriteFile(layerID+"/VERSION", strings.NewReader(dockerLayerVersion), int64(len(dockerLayerVersion))); err != nil {
+		return err
+	}
+	if err := writeJSONFile(writer, layerID+"/json", dockerLayerJSON{ID: layerID, Parent: parent}); err != nil {
+		return err
+	}
+	return writer.WriteFile(layerID+"/layer.tar", layerTar, info.Size())
+}
+
+func writeJSONFile(writer layoutWriter, name string, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writer.WriteFile(name, strings.NewReader(string(content)), int64(len(content)))
+}
@@ -0,0 +1,83 @@
+package image
+
+import (
+	"time"
+)
+
+// History records how a layer of an image was created, the image config
+// holds one entry per step, the oldest first. Steps which only changed the
+// config are marked as empty layers.
+type History struct {
+	Created    string `json:"created,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	Author     string `json:"author,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+}
+
+// Append a history entry for the step producing img, created now
+func (img *Image) AddHistory(createdBy, comment string, emptyLayer bool) {
+	img.Created = time.Now().UTC().Format(time.RFC3339)
+	img.History = append(img.History, History{
+		Created:    img.Created,
+		CreatedBy:  createdBy,
+		Author:     img.Author,
+		Comment:    comment,
+		EmptyLayer: emptyLayer,
+	})
+}
+
+// HistoryEntry is a step of an image history together with the layer it
+// created, as printed by `history`
+type HistoryEntry struct {
+	Id         string `json:"id"`
+	Created    string `json:"created"`
+	CreatedBy  string `json:"createdBy"`
+	Comment    string `json:"comment"`
+	EmptyLayer bool   `json:"emptyLayer"`
+	Layer      string `json:"layer,omitempty"`
+	Size       int64  `json:"size"`
+}
+
+// Return the history of an image, the newest step first. Only the newest
+// step carries the image ID, the images of other steps are not known.
+// Layers predating the recorded history, for example of images pulled
+// without one, are listed as steps with an empty command.
+func GetHistory(ref string) ([]*HistoryEntry, error) {
+	id, manifest, img, err := GetImage(ref)
+	if err != nil {
+		return nil, err
+	}
+	steps := img.History
+	recorded := 0
+	for _, step := range steps {
+		if !step.EmptyLayer {
+			recorded++
+		}
+	}
+	if missing := len(manifest.Layers) - recorded; missing > 0 {
+		steps = append(make([]History, missing), steps...)
+	}
+
+	var entries []*HistoryEntry
+	layer := 0
+	for _, step := range steps {
+		entry := &HistoryEntry{
+			Id:         "<missing>",
+			Created:    step.Created,
+			CreatedBy:  step.CreatedBy,
+			Comment:    step.Comment,
+			EmptyLayer: step.EmptyLayer,
+		}
+		if !step.EmptyLayer && layer < len(manifest.Layers) {
+			entry.Layer = manifest.Layers[layer].Digest
+			entry.Size = manifest.Layers[layer].Size
+			layer++
+		}
+		entries = append([]*HistoryEntry{entry}, entries...)
+	}
+	if len(entries) > 0 {
+		entries[0].Id = id
+	}
+	return entries, nil
+}
@@ -0,0 +1,232 @@
+package image
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"time"
+)
+
+// Media types follow the OCI image spec, so that blobs in the store can
+// be exchanged with other tools without conversion
+const (
+	MediaTypeIndex        = "application/vnd.oci.image.index.v1+json"
+	MediaTypeManifest     = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeImageConfig  = "application/vnd.oci.image.config.v1+json"
+	MediaTypeLayer        = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeLayerGzip    = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeLayerZstd    = "application/vnd.oci.image.layer.v1.tar+zstd"
+	manifestSchemaVersion = 2
+)
+
+// Descriptor points to a blob in the store
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+// Manifest references the config and the ordered layers of an image,
+// the first layer is the base layer
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// Image is the image config blob
+type Image struct {
+	Created      string      `json:"created,omitempty"`
+	Author       string      `json:"author,omitempty"`
+	Comment      string      `json:"comment,omitempty"`
+	Architecture string      `json:"architecture"`
+	OS           string      `json:"os"`
+	Config       ImageConfig `json:"config"`
+	RootFS       RootFS      `json:"rootfs"`
+	History      []History   `json:"history,omitempty"`
+}
+
+// ImageConfig holds defaults for containers created from the image
+type ImageConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+// RootFS lists digests of the uncompressed layers
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// Layer is a layer blob together with the digest of its uncompressed content
+type Layer struct {
+	Descriptor *Descriptor
+	DiffID     string
+}
+
+func NewImage() *Image {
+	return &Image{
+		Created:      time.Now().UTC().Format(time.RFC3339),
+		Architecture: runtime.GOARCH,
+		OS:           runtime.GOOS,
+		RootFS: RootFS{
+			Type: "layers",
+		},
+	}
+}
+
+// Write config and manifest of an image built from layers (base layer
+// first) into the store and tag it as ref. It returns the image ID, that
+// is the digest of the manifest.
+func CreateImage(ref string, img *Image, layers []*Layer) (string, error) {
+	img.RootFS.DiffIDs = nil
+	for _, layer := range layers {
+		img.RootFS.DiffIDs = append(img.RootFS.DiffIDs, layer.DiffID)
+	}
+
+	configDesc, err := WriteJSONBlob(img, MediaTypeImageConfig)
+	if err != nil {
+		return "", fmt.Errorf("Write image config error: %v", err)
+	}
+	return createImageFromConfig(ref, configDesc, layers)
+}
+
+// Write the manifest of an image whose config blob is already in the store
+func createImageFromConfig(ref string, configDesc *Descriptor, layers []*Layer) (string, error) {
+	manifest := &Manifest{
+		SchemaVersion: manifestSchemaVersion,
+		MediaType:     MediaTypeManifest,
+		Config:        *configDesc,
+	}
+	for _, layer := range layers {
+		manifest.Layers = append(manifest.Layers, *layer.Descriptor)
+	}
+
+	manifestDesc, err := WriteJSONBlob(manifest, MediaTypeManifest)
+	if err != nil {
+		return "", fmt.Errorf("Write image manifest error: %v", err)
+	}
+	log.Infof("Create image %s with %d layers", manifestDesc.Digest, len(layers))
+	if err := registerImage(manifestDesc.Digest, ref); err != nil {
+		return "", err
+	}
+	return manifestDesc.Digest, nil
+}
+
+// Record a manifest already in the blob store as an image, and tag
+// it as ref if ref is not empty
+func registerImage(id, ref string) error {
+	if err := os.MkdirAll(imagedbDir(), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(imagedbDir(), digestHex(id)), nil, 0644); err != nil {
+		return err
+	}
+	if ref == "" {
+		return nil
+	}
+	return Tag(id, ref)
+}
+
+// Return the manifest and config of the image referenced by ref,
+// ref is either name[:tag] or an image ID
+func GetImage(ref string) (string, *Manifest, *Image, error) {
+	id, err := Resolve(ref)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	manifest, err := GetManifest(id)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	var img Image
+	if err := readJSONBlob(manifest.Config.Digest, &img); err != nil {
+		return "", nil, nil, fmt.Errorf("Read image %s config error: %v", ref, err)
+	}
+	return id, manifest, &img, nil
+}
+
+// Return layers of an image, the base layer first, pairing the blobs
+// listed by the manifest with the diff IDs of the config
+func ImageLayers(manifest *Manifest, img *Image) ([]*Layer, error) {
+	if len(img.RootFS.DiffIDs) != len(manifest.Layers) {
+		return nil, fmt.Errorf("Image config lists %d layers, manifest has %d",
+			len(img.RootFS.DiffIDs), len(manifest.Layers))
+	}
+	var layers []*Layer
+	for i := range manifest.Layers {
+		layers = append(layers, &Layer{
+			Descriptor: &manifest.Layers[i],
+			DiffID:     img.RootFS.DiffIDs[i],
+		})
+	}
+	return layers, nil
+}
+
+// Return IDs of all images in the store, tagged or not
+func ListImageIDs() ([]string, error) {
+	files, err := ioutil.ReadDir(imagedbDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, file := range files {
+		ids = append(ids, digestAlgorithm+":"+file.Name())
+	}
+	return ids, nil
+}
+
+func GetManifest(id string) (*Manifest, error) {
+	var manifest Manifest
+	if err := readJSONBlob(id, &manifest); err != nil {
+		return nil, fmt.Errorf("Read image %s manifest error: %v", id, err)
+	}
+	return &manifest, nil
+}
+
+// Import a rootfs tarball or directory as a single-layer image, "-" reads
+// the tarball from STDIN. config sets the default command and environment
+// of the image if not nil.
+func Import(src, ref string, config *ImageConfig) (string, error) {
+	var layer *Layer
+	var err error
+	if src == "-" {
+		layer, err = WriteLayer(os.Stdin)
+	} else if info, statErr := os.Stat(src); statErr != nil {
+		return "", statErr
+	} else if info.IsDir() {
+		layer, err = WriteLayerDir(src, nil)
+	} else {
+		layer, err = WriteLayerFile(src)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Import %s error: %v", src, err)
+	}
+
+	img := NewImage()
+	if config != nil {
+		img.Config = *config
+	}
+	img.AddHistory("", "Imported from "+src, false)
+	return CreateImage(ref, img, []*Layer{layer})
+}
@@ -0,0 +1,498 @@
+package image
+
+import (
+	"../misc"
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseReference(t *testing.T) {
+	cases := map[string][2]string{
+		"busybox":                    {"busybox", "latest"},
+		"busybox:1.29":               {"busybox", "1.29"},
+		"localhost:5000/busybox":     {"localhost:5000/busybox", "latest"},
+		"localhost:5000/busybox:1.0": {"localhost:5000/busybox", "1.0"},
+	}
+	for ref, expected := range cases {
+		name, tag := ParseReference(ref)
+		if name != expected[0] || tag != expected[1] {
+			t.Errorf("ParseReference(%q) = %q, %q", ref, name, tag)
+		}
+	}
+}
+
+func setupStore(t *testing.T) func() {
+	root, err := ioutil.TempDir("", "mydocker-image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	StoreUrl = root
+	return func() {
+		os.RemoveAll(root)
+	}
+}
+
+func testLayer(t *testing.T) *Layer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "hello", Mode: 0644, Size: 5})
+	tw.Write([]byte("world"))
+	tw.Close()
+
+	layer, err := WriteLayer(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("write layer %v", err)
+	}
+	return layer
+}
+
+func TestCreateImage(t *testing.T) {
+	defer setupStore(t)()
+
+	layer := testLayer(t)
+	id, err := CreateImage("hello", NewImage(), []*Layer{layer, layer})
+	if err != nil {
+		t.Fatalf("create image %v", err)
+	}
+
+	for _, ref := range []string{"hello", "hello:latest", id, digestHex(id)[:12]} {
+		resolved, err := Resolve(ref)
+		if err != nil || resolved != id {
+			t.Errorf("Resolve(%q) = %q, %v", ref, resolved, err)
+		}
+	}
+	_, manifest, img, err := GetImage("hello")
+	if err != nil {
+		t.Fatalf("get image %v", err)
+	}
+	if len(manifest.Layers) != 2 || manifest.Layers[0].Digest != manifest.Layers[1].Digest {
+		t.Errorf("unexpected layers %+v", manifest.Layers)
+	}
+	if img.RootFS.DiffIDs[0] != layer.DiffID {
+		t.Errorf("unexpected diff ids %v", img.RootFS.DiffIDs)
+	}
+}
+
+func TestExtractLayer(t *testing.T) {
+	defer setupStore(t)()
+
+	layer := testLayer(t)
+	if _, err := CreateImage("hello", NewImage(), []*Layer{layer}); err != nil {
+		t.Fatalf("create image %v", err)
+	}
+	// An interrupted extraction leaves a directory without marker
+	dir := layerDir("aufs", layer.Descriptor.Digest)
+	os.MkdirAll(dir, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "partial"), nil, 0644)
+
+	errs := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			_, err := LayerDirs("hello", "aufs")
+			errs <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("extract layer %v", err)
+		}
+	}
+
+	if content, err := ioutil.ReadFile(filepath.Join(dir, "hello")); err != nil || string(content) != "world" {
+		t.Errorf("hello = %q, %v", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "partial")); !os.IsNotExist(err) {
+		t.Errorf("partial extraction is kept: %v", err)
+	}
+	if leftovers, _ := filepath.Glob(dir + ".tmp-*"); len(leftovers) != 0 {
+		t.Errorf("temporary dirs are kept: %v", leftovers)
+	}
+}
+
+func TestOCIRoundTrip(t *testing.T) {
+	defer setupStore(t)()
+	id, err := CreateImage("hello:1.0", NewImage(), []*Layer{testLayer(t)})
+	if err != nil {
+		t.Fatalf("create image %v", err)
+	}
+
+	for _, dst := range []string{"layout", "layout.tar"} {
+		out, err := ioutil.TempDir("", "mydocker-oci")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(out)
+		if err := ExportOCI("hello:1.0", out+"/"+dst); err != nil {
+			t.Fatalf("export %s %v", dst, err)
+		}
+
+		defer setupStore(t)()
+		ids, err := ImportOCI(out+"/"+dst, "")
+		if err != nil {
+			t.Fatalf("import %s %v", dst, err)
+		}
+		if len(ids) != 1 || ids[0] != id {
+			t.Errorf("imported %v, expected %s", ids, id)
+		}
+		if resolved, _ := Resolve("hello:1.0"); resolved != id {
+			t.Errorf("ref name annotation not imported from %s", dst)
+		}
+	}
+}
+
+func TestDockerArchiveRoundTrip(t *testing.T) {
+	defer setupStore(t)()
+	id, err := CreateImage("hello:1.0", NewImage(), []*Layer{testLayer(t)})
+	if err != nil {
+		t.Fatalf("create image %v", err)
+	}
+
+	var archive bytes.Buffer
+	if err := SaveDockerArchive([]string{"hello:1.0"}, &archive); err != nil {
+		t.Fatalf("save %v", err)
+	}
+
+	defer setupStore(t)()
+	loaded, err := LoadDockerArchive(&archive)
+	if err != nil {
+		t.Fatalf("load %v", err)
+	}
+	if len(loaded) != 1 || loaded[0] != "hello:1.0" {
+		t.Errorf("loaded %v", loaded)
+	}
+	// The manifest is rebuilt from the same config and layers
+	if resolved, _ := Resolve("hello:1.0"); resolved != id {
+		t.Errorf("loaded image %s, expected %s", resolved, id)
+	}
+}
+
+func TestLoadArchiveStaysInTempDir(t *testing.T) {
+	defer setupStore(t)()
+	// Archives are unpacked under TMPDIR, the chain of symlinks below
+	// would put d/l/m/x right in it
+	tmp, err := ioutil.TempDir("", "mydocker-tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", tmp)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "d/", Mode: 0755, Typeflag: tar.TypeDir})
+	tw.WriteHeader(&tar.Header{Name: "d/l", Linkname: "..", Mode: 0777, Typeflag: tar.TypeSymlink})
+	tw.WriteHeader(&tar.Header{Name: "d/l/m", Linkname: "..", Mode: 0777, Typeflag: tar.TypeSymlink})
+	tw.WriteHeader(&tar.Header{Name: "d/l/m/x", Mode: 0644, Size: 2})
+	tw.Write([]byte("{}"))
+	manifest := []byte(`[{"Config": "d/l/m/x", "RepoTags": ["escape:latest"], "Layers": []}]`)
+	tw.WriteHeader(&tar.Header{Name: dockerManifestFile, Mode: 0644, Size: int64(len(manifest))})
+	tw.Write(manifest)
+	tw.Close()
+
+	LoadDockerArchive(bytes.NewReader(buf.Bytes()))
+	if _, err := os.Lstat(filepath.Join(tmp, "x")); err == nil {
+		t.Error("load followed symlinks out of its temporary directory")
+	}
+
+	archive := filepath.Join(tmp, "layout.tar")
+	ioutil.WriteFile(archive, buf.Bytes(), 0644)
+	ImportOCI(archive, "")
+	if _, err := os.Lstat(filepath.Join(tmp, "x")); err == nil {
+		t.Error("import followed symlinks out of its temporary directory")
+	}
+}
+
+func TestDeleteImageKeepsSharedLayers(t *testing.T) {
+	defer setupStore(t)()
+	layer := testLayer(t)
+	base, err := CreateImage("base", NewImage(), []*Layer{layer})
+	if err != nil {
+		t.Fatalf("create image %v", err)
+	}
+	img := NewImage()
+	img.Author = "test"
+	child, err := CreateImage("child", img, []*Layer{layer, layer})
+	if err != nil {
+		t.Fatalf("create image %v", err)
+	}
+
+	Untag("child")
+	deleted, err := DeleteImage(child)
+	if err != nil {
+		t.Fatalf("delete image %v", err)
+	}
+	for _, digest := range deleted {
+		if digest == layer.Descriptor.Digest {
+			t.Errorf("shared layer %s deleted", digest)
+		}
+	}
+	if _, _, _, err := GetImage(base); err != nil {
+		t.Errorf("base image broken %v", err)
+	}
+	if ids, _ := ListImageIDs(); len(ids) != 1 {
+		t.Errorf("expected 1 image, got %v", ids)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	defer setupStore(t)()
+	rootfs, _ := ioutil.TempDir("", "mydocker-rootfs")
+	defer os.RemoveAll(rootfs)
+	ioutil.WriteFile(filepath.Join(rootfs, "hello"), []byte("world"), 0644)
+	if _, err := Import(rootfs, "base", nil); err != nil {
+		t.Fatalf("import %v", err)
+	}
+	diffDir, _ := ioutil.TempDir("", "mydocker-diff")
+	defer os.RemoveAll(diffDir)
+	ioutil.WriteFile(filepath.Join(diffDir, "added"), []byte("content"), 0644)
+	options := &CommitOptions{Author: "tester", Comment: "add a file", CreatedBy: "/bin/sh"}
+	id, err := Commit("base", diffDir, "child", options)
+	if err != nil {
+		t.Fatalf("commit %v", err)
+	}
+
+	history, err := GetHistory("child")
+	if err != nil || len(history) != 2 {
+		t.Fatalf("history = %v, %v", history, err)
+	}
+	_, manifest, _, _ := GetImage(id)
+	if history[0].Id != id || history[0].CreatedBy != "/bin/sh" || history[0].Comment != "add a file" ||
+		history[0].Size != manifest.Layers[1].Size {
+		t.Errorf("unexpected commit step %+v", history[0])
+	}
+	if history[1].Id != "<missing>" || history[1].Comment != "Imported from "+rootfs ||
+		history[1].Layer != manifest.Layers[0].Digest {
+		t.Errorf("unexpected import step %+v", history[1])
+	}
+}
+
+func TestConvertSquashfs(t *testing.T) {
+	if _, err := exec.LookPath("mksquashfs"); err != nil || os.Getuid() != 0 {
+		t.Skip("requires mksquashfs and root")
+	}
+	defer setupStore(t)()
+	layer := testLayer(t)
+	id, err := CreateImage("hello", NewImage(), []*Layer{layer})
+	if err != nil {
+		t.Fatalf("create image %v", err)
+	}
+	if err := ConvertSquashfs("hello", "aufs", nil); err != nil {
+		t.Fatalf("convert %v", err)
+	}
+	if exist, _ := pathExists(layerDir("aufs", layer.Descriptor.Digest)); exist {
+		t.Errorf("extracted copy of converted layer kept")
+	}
+
+	dirs, err := LayerDirs("hello", "aufs")
+	if err != nil {
+		t.Fatalf("mount layers %v", err)
+	}
+	if dirs[0] != squashfsMountPath("aufs", layer.Descriptor.Digest) {
+		t.Errorf("unexpected lower dirs %v", dirs)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(dirs[0], "hello")); err != nil || string(content) != "world" {
+		t.Errorf("hello = %q, %v", content, err)
+	}
+
+	Untag("hello")
+	if _, err := DeleteImage(id); err != nil {
+		t.Fatalf("delete image %v", err)
+	}
+	if mounted, _ := misc.IsMounted(dirs[0]); mounted {
+		t.Errorf("squashfs layer still mounted")
+	}
+	if entries, _ := filepath.Glob(layerDir("aufs", layer.Descriptor.Digest) + "*"); len(entries) != 0 {
+		t.Errorf("layer entries kept: %v", entries)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	defer setupStore(t)()
+	layerOf := func(content string) *Layer {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Name: "file", Mode: 0644, Size: int64(len(content))})
+		tw.Write([]byte(content))
+		tw.Close()
+		layer, err := WriteLayer(&buf)
+		if err != nil {
+			t.Fatalf("write layer %v", err)
+		}
+		return layer
+	}
+	shared, dangling, used := layerOf("shared"), layerOf("dangling"), layerOf("used")
+
+	tagged, err := CreateImage("tagged", NewImage(), []*Layer{shared})
+	if err != nil {
+		t.Fatalf("create image %v", err)
+	}
+	danglingId, _ := CreateImage("", NewImage(), []*Layer{shared, dangling})
+	usedId, _ := CreateImage("", NewImage(), []*Layer{used})
+	if _, err := LayerDirs(danglingId, "aufs"); err != nil {
+		t.Fatalf("extract layers %v", err)
+	}
+	// Unreferenced blobs, one left long ago by an interrupted pull
+	old, _ := WriteBlob(bytes.NewReader([]byte("old")), MediaTypeLayer)
+	fresh, _ := WriteBlob(bytes.NewReader([]byte("fresh")), MediaTypeLayer)
+	longAgo := time.Now().Add(-2 * pruneGracePeriod)
+	os.Chtimes(blobPath(old.Digest), longAgo, longAgo)
+
+	report, err := Prune(false, map[string]bool{usedId: true})
+	if err != nil {
+		t.Fatalf("prune %v", err)
+	}
+	if ids, _ := ListImageIDs(); len(ids) != 2 {
+		t.Errorf("expected tagged and used images, got %v", ids)
+	}
+	for _, digest := range []string{shared.Descriptor.Digest, used.Descriptor.Digest, fresh.Digest} {
+		if exist, _ := pathExists(blobPath(digest)); !exist {
+			t.Errorf("blob %s deleted", digest)
+		}
+	}
+	for _, digest := range []string{danglingId, dangling.Descriptor.Digest, old.Digest} {
+		if exist, _ := pathExists(blobPath(digest)); exist {
+			t.Errorf("blob %s kept", digest)
+		}
+	}
+	if exist, _ := pathExists(layerDir("aufs", dangling.Descriptor.Digest)); exist {
+		t.Errorf("extracted layer of deleted blob kept")
+	}
+	if exist, _ := pathExists(layerDir("aufs", shared.Descriptor.Digest)); !exist {
+		t.Errorf("extracted shared layer deleted")
+	}
+	if report.SpaceReclaimed <= int64(len("dangling")) {
+		t.Errorf("unexpected space reclaimed %d", report.SpaceReclaimed)
+	}
+
+	report, err = Prune(true, map[string]bool{usedId: true})
+	if err != nil {
+		t.Fatalf("prune all %v", err)
+	}
+	if len(report.Untagged) != 1 || report.Untagged[0] != "tagged:latest" {
+		t.Errorf("unexpected untagged %v", report.Untagged)
+	}
+	if _, err := Resolve(tagged); err == nil {
+		t.Errorf("tagged image kept")
+	}
+	if exist, _ := pathExists(layerDir("aufs", shared.Descriptor.Digest)); exist {
+		t.Errorf("extracted layer of unused image kept")
+	}
+	if _, _, _, err := GetImage(usedId); err != nil {
+		t.Errorf("image used by a container broken %v", err)
+	}
+}
+
+func TestCommitOverlayDiff(t *testing.T) {
+	defer setupStore(t)()
+	if _, err := CreateImage("base", NewImage(), []*Layer{testLayer(t)}); err != nil {
+		t.Fatalf("create image %v", err)
+	}
+
+	diff, err := ioutil.TempDir("", "mydocker-diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(diff)
+	os.MkdirAll(diff+"/etc/opaque", 0755)
+	ioutil.WriteFile(diff+"/etc/added", []byte("new"), 0644)
+	if err := syscall.Mknod(diff+"/etc/removed", syscall.S_IFCHR, 0); err != nil {
+		t.Skipf("mknod requires root: %v", err)
+	}
+	syscall.Setxattr(diff+"/etc/opaque", overlayOpaque, []byte("y"), 0)
+
+	id, err := Commit("base", diff, "child", &CommitOptions{Author: "tester"})
+	if err != nil {
+		t.Fatalf("commit %v", err)
+	}
+	_, manifest, img, err := GetImage(id)
+	if err != nil {
+		t.Fatalf("get image %v", err)
+	}
+	if len(manifest.Layers) != 2 || img.Author != "tester" {
+		t.Fatalf("unexpected image %+v %+v", manifest, img)
+	}
+
+	blob, _ := OpenBlob(manifest.Layers[1].Digest)
+	defer blob.Close()
+	names := map[string]bool{}
+	tr := tar.NewReader(blob)
+	for hdr, err := tr.Next(); err == nil; hdr, err = tr.Next() {
+		names[hdr.Name] = true
+	}
+	for _, name := range []string{"etc/added", "etc/.wh.removed", "etc/opaque/.wh..wh..opq"} {
+		if !names[name] {
+			t.Errorf("%s missing in layer %v", name, names)
+		}
+	}
+}
+
+func TestChanges(t *testing.T) {
+	root, err := ioutil.TempDir("", "mydocker-changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	lower, upper := root+"/lower", root+"/upper"
+	os.MkdirAll(lower+"/etc", 0755)
+	os.MkdirAll(upper+"/etc", 0755)
+	ioutil.WriteFile(lower+"/etc/passwd", nil, 0644)
+	ioutil.WriteFile(lower+"/etc/old", nil, 0644)
+	ioutil.WriteFile(upper+"/etc/passwd", []byte("root"), 0644)
+	ioutil.WriteFile(upper+"/etc/new", nil, 0644)
+	ioutil.WriteFile(upper+"/etc/.wh.old", nil, 0644)
+
+	changes, err := Changes(upper, []string{lower})
+	if err != nil {
+		t.Fatalf("changes %v", err)
+	}
+	expected := []Change{
+		{"/etc", ChangeModify},
+		{"/etc/new", ChangeAdd},
+		{"/etc/old", ChangeDelete},
+		{"/etc/passwd", ChangeModify},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("changes %v, expected %v", changes, expected)
+	}
+
+	// Files under a directory removed or made opaque by a higher lower
+	// layer are added, not modified
+	middle := root + "/middle"
+	os.MkdirAll(lower+"/opt/app", 0755)
+	os.MkdirAll(lower+"/srv/www", 0755)
+	ioutil.WriteFile(lower+"/opt/app/run", nil, 0755)
+	ioutil.WriteFile(lower+"/srv/www/index.html", nil, 0644)
+	os.MkdirAll(middle+"/srv/www", 0755)
+	ioutil.WriteFile(middle+"/.wh.opt", nil, 0644)
+	ioutil.WriteFile(middle+"/srv/"+WhiteoutOpaque, nil, 0644)
+	os.RemoveAll(upper)
+	os.MkdirAll(upper+"/opt/app", 0755)
+	os.MkdirAll(upper+"/srv/www", 0755)
+	ioutil.WriteFile(upper+"/opt/app/run", nil, 0755)
+	ioutil.WriteFile(upper+"/srv/www/index.html", nil, 0644)
+
+	changes, err = Changes(upper, []string{middle, lower})
+	if err != nil {
+		t.Fatalf("changes %v", err)
+	}
+	expected = []Change{
+		{"/opt", ChangeAdd},
+		{"/opt/app", ChangeAdd},
+		{"/opt/app/run", ChangeAdd},
+		{"/srv", ChangeModify},
+		{"/srv/www", ChangeModify},
+		{"/srv/www/index.html", ChangeAdd},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("changes %v, expected %v", changes, expected)
+	}
+}
@@ -0,0 +1,149 @@
+package image
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// ImageInfo summarizes an image for `images` and `image inspect`
+type ImageInfo struct {
+	Id       string    `json:"id"`
+	RepoTags []string  `json:"repoTags"`
+	Created  string    `json:"created"`
+	Size     int64     `json:"size"`
+	Manifest *Manifest `json:"manifest"`
+	Config   *Image    `json:"config"`
+}
+
+func GetImageInfo(ref string) (*ImageInfo, error) {
+	id, manifest, img, err := GetImage(ref)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := GetTags(id)
+	if err != nil {
+		return nil, err
+	}
+	info := &ImageInfo{
+		Id:       id,
+		RepoTags: tags,
+		Created:  img.Created,
+		Manifest: manifest,
+		Config:   img,
+	}
+	for _, layer := range manifest.Layers {
+		info.Size += layer.Size
+	}
+	return info, nil
+}
+
+// Return images in the store, the newest first. Untagged intermediate
+// images of builds are only included if all is set.
+func ListImages(all bool) ([]*ImageInfo, error) {
+	ids, err := ListImageIDs()
+	if err != nil {
+		return nil, err
+	}
+	intermediate, err := intermediateImages()
+	if err != nil {
+		return nil, err
+	}
+	var infos []*ImageInfo
+	for _, id := range ids {
+		info, err := GetImageInfo(id)
+		if err != nil {
+			return nil, err
+		}
+		if !all && len(info.RepoTags) == 0 && intermediate[id] {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Created > infos[j].Created
+	})
+	return infos, nil
+}
+
+// Remove an image from the store together with blobs and extracted
+// layers that no other image uses. References to the image should
+// have been removed by Untag.
+func DeleteImage(id string) ([]string, error) {
+	manifest, err := GetManifest(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path.Join(imagedbDir(), digestHex(id))); err != nil {
+		return nil, err
+	}
+	if err := deleteSignatures(id); err != nil {
+		return nil, err
+	}
+
+	refs, err := blobRefs()
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	blobs := append([]Descriptor{{Digest: id}, manifest.Config}, manifest.Layers...)
+	for _, blob := range blobs {
+		if refs[blob.Digest] > 0 {
+			continue
+		}
+		if _, err := deleteBlob(blob.Digest); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, blob.Digest)
+	}
+	return deleted, nil
+}
+
+// Count references of images in the store to each blob. A layer shared
+// by several images is only deleted when its count drops to zero.
+func blobRefs() (map[string]int, error) {
+	ids, err := ListImageIDs()
+	if err != nil {
+		return nil, err
+	}
+	refs := map[string]int{}
+	for _, id := range ids {
+		manifest, err := GetManifest(id)
+		if err != nil {
+			return nil, err
+		}
+		refs[id]++
+		refs[manifest.Config.Digest]++
+		for _, layer := range manifest.Layers {
+			refs[layer.Digest]++
+		}
+	}
+	return refs, nil
+}
+
+// Remove a blob and the layers extracted from it by any storage driver,
+// and return the bytes freed
+func deleteBlob(digest string) (int64, error) {
+	// Extracted layer, its marker, lock, squashfs file and mount point,
+	// and leftovers of interrupted extractions
+	entries, err := filepath.Glob(path.Join(StoreUrl, "layers", "*", digestHex(digest)+"*"))
+	if err != nil {
+		return 0, err
+	}
+	var freed int64
+	for _, entry := range entries {
+		size, err := removeLayerEntry(entry)
+		freed += size
+		if err != nil {
+			return freed, err
+		}
+	}
+	if info, err := os.Stat(blobPath(digest)); err == nil {
+		freed += info.Size()
+	}
+	if err := os.Remove(blobPath(digest)); err != nil && !os.IsNotExist(err) {
+		return freed, err
+	}
+	return freed, nil
+}
@@ -0,0 +1,215 @@
+package image
+
+import (
+	"../archive"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+func layerDir(storageDriver, digest string) string {
+	return path.Join(StoreUrl, "layers", storageDriver, digestHex(digest))
+}
+
+// Copy a layer tarball into the store. The diff ID is the digest of the
+// uncompressed tarball, it equals the blob digest unless the layer is
+// compressed with gzip or zstd.
+func WriteLayer(r io.Reader) (*Layer, error) {
+	desc, err := WriteBlob(r, MediaTypeLayer)
+	if err != nil {
+		return nil, err
+	}
+	layer := &Layer{
+		Descriptor: desc,
+		DiffID:     desc.Digest,
+	}
+
+	blob, err := OpenBlob(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	reader, compression, err := archive.DecompressStream(blob)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	switch compression {
+	case archive.Uncompressed:
+		return layer, nil
+	case archive.Gzip:
+		desc.MediaType = MediaTypeLayerGzip
+	case archive.Zstd:
+		desc.MediaType = MediaTypeLayerZstd
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return nil, fmt.Errorf("Decompress layer %s error: %v", desc.Digest, err)
+	}
+	layer.DiffID = digestAlgorithm + ":" + hex.EncodeToString(hash.Sum(nil))
+	return layer, nil
+}
+
+// Open the uncompressed tarball of a layer blob. A compressed layer is
+// decompressed into a temporary file, which is removed when closed.
+func OpenLayerTar(digest string) (*os.File, error) {
+	blob, err := OpenBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+	reader, compression, err := archive.DecompressStream(blob)
+	if err != nil {
+		blob.Close()
+		return nil, err
+	}
+	if compression == archive.Uncompressed {
+		// Rewind, peeking the magic number has buffered some content
+		if _, err := blob.Seek(0, io.SeekStart); err != nil {
+			blob.Close()
+			return nil, err
+		}
+		return blob, nil
+	}
+	defer blob.Close()
+	defer reader.Close()
+
+	tmpFile, err := ioutil.TempFile("", "mydocker-layer-")
+	if err != nil {
+		return nil, err
+	}
+	// The file stays readable through the open descriptor after unlink
+	os.Remove(tmpFile.Name())
+	if _, err := io.Copy(tmpFile, reader); err != nil {
+		tmpFile.Close()
+		return nil, fmt.Errorf("Decompress layer %s error: %v", digest, err)
+	}
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		tmpFile.Close()
+		return nil, err
+	}
+	return tmpFile, nil
+}
+
+// Archive the content of dir as a layer, paths listed in excludes
+// (relative to dir) are left out
+func WriteLayerDir(dir string, excludes []string) (*Layer, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(TarDir(dir, writer, excludes))
+	}()
+	layer, err := WriteLayer(reader)
+	reader.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Archive %s error: %v", dir, err)
+	}
+	log.Infof("$ tar -cf %s -C %s .", blobPath(layer.Descriptor.Digest), dir)
+	return layer, nil
+}
+
+func WriteLayerFile(tarPath string) (*Layer, error) {
+	file, err := os.Open(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	log.Infof("$ cp %s %s", tarPath, blobDir())
+	return WriteLayer(file)
+}
+
+// Return extracted layer directories of an image, the top-most layer
+// comes first as expected by storage drivers. Layers are extracted from
+// their blobs on first use and shared by all images containing them.
+func LayerDirs(ref, storageDriver string) ([]string, error) {
+	_, manifest, _, err := GetImage(ref)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, layer := range manifest.Layers {
+		dir, err := extractLayer(layer, storageDriver)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append([]string{dir}, dirs...)
+	}
+	return dirs, nil
+}
+
+// Extract a layer blob once per storage driver. Concurrent containers
+// starting from the same image wait on a lock, the layer is unpacked into
+// a temporary directory and renamed into place, then a marker records the
+// extraction as complete. A directory without marker is left over by an
+// interrupted extraction and is unpacked again.
+func extractLayer(layer Descriptor, storageDriver string) (string, error) {
+	// A layer converted to squashfs is mounted instead
+	if mnt, err := mountSquashfs(layer, storageDriver); err != nil || mnt != "" {
+		return mnt, err
+	}
+	dir := layerDir(storageDriver, layer.Digest)
+	marker := dir + ".done"
+	if exist, err := pathExists(marker); err != nil || exist {
+		return dir, err
+	}
+
+	parent := path.Dir(dir)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	lock, err := lockFile(dir + ".lock")
+	if err != nil {
+		return "", err
+	}
+	defer lock.Close()
+
+	// Another process may have finished the extraction while we waited
+	if exist, err := pathExists(marker); err != nil || exist {
+		return dir, err
+	}
+	leftovers, _ := filepath.Glob(dir + ".tmp-*")
+	for _, leftover := range append(leftovers, dir) {
+		if err := os.RemoveAll(leftover); err != nil {
+			return "", err
+		}
+	}
+
+	tmpDir, err := ioutil.TempDir(parent, path.Base(dir)+".tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+		return "", err
+	}
+	log.Infof("$ mkdir -p %s -m 0755", tmpDir)
+
+	blob, err := OpenBlob(layer.Digest)
+	if err != nil {
+		return "", err
+	}
+	defer blob.Close()
+	log.Infof("$ tar -xf %s -C %s", blobPath(layer.Digest), tmpDir)
+	if err := archive.Untar(blob, tmpDir); err != nil {
+		return "", fmt.Errorf("Extract layer %s error: %v", layer.Digest, err)
+	}
+
+	if needWhiteoutConversion(storageDriver) {
+		if err := convertOverlayWhiteouts(tmpDir); err != nil {
+			return "", fmt.Errorf("Convert whiteouts of layer %s error: %v", layer.Digest, err)
+		}
+	}
+
+	if err := os.Rename(tmpDir, dir); err != nil {
+		return "", err
+	}
+	log.Infof("$ mv %s %s", tmpDir, dir)
+	if err := ioutil.WriteFile(marker, nil, 0644); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
@@ -0,0 +1,357 @@
+package image
+
+import (
+	"../archive"
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// An OCI image layout is a directory (or a tarball of it) structured as
+//
+// <layout>
+// |-- oci-layout                   {"imageLayoutVersion": "1.0.0"}
+// |-- index.json                   entry point, lists image manifests
+// `-- blobs/sha256/<hex>           manifests, configs and layers
+//
+// Blobs are copied verbatim, so an image keeps the same ID after being
+// imported and exported.
+const (
+	ociLayoutFile         = "oci-layout"
+	ociIndexFile          = "index.json"
+	ociLayoutVersion      = "1.0.0"
+	AnnotationRefName     = "org.opencontainers.image.ref.name"
+	mediaTypeDockerList   = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerSchema = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+type ociLayout struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
+
+// Index lists manifests, it is both the index.json of an image layout
+// and the blob format of a multi-platform image
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// Import images from an OCI image layout directory or tarball. If ref is
+// not empty the image is tagged as ref, otherwise the ref name annotation
+// in index.json is used. It returns IDs of imported images.
+func ImportOCI(src, ref string) ([]string, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	layoutDir := src
+	if !info.IsDir() {
+		tmpDir, err := ioutil.TempDir("", "mydocker-oci-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmpDir)
+		file, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if err := archive.Untar(file, tmpDir); err != nil {
+			return nil, fmt.Errorf("Unpack %s error: %v", src, err)
+		}
+		layoutDir = tmpDir
+	}
+
+	var layout ociLayout
+	layoutFile, err := archivePath(layoutDir, ociLayoutFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := readJSONFile(layoutFile, &layout); err != nil {
+		return nil, fmt.Errorf("%s is not an OCI image layout: %v", src, err)
+	}
+	if layout.ImageLayoutVersion != ociLayoutVersion {
+		return nil, fmt.Errorf("Unsupported image layout version %s", layout.ImageLayoutVersion)
+	}
+	var index Index
+	indexFile, err := archivePath(layoutDir, ociIndexFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := readJSONFile(indexFile, &index); err != nil {
+		return nil, err
+	}
+	if ref != "" && len(index.Manifests) != 1 {
+		return nil, fmt.Errorf("Layout %s holds %d images, cannot tag all of them as %s",
+			src, len(index.Manifests), ref)
+	}
+
+	var ids []string
+	for _, desc := range index.Manifests {
+		imageRef := ref
+		if imageRef == "" {
+			imageRef = refFromAnnotation(desc.Annotations[AnnotationRefName])
+		}
+		id, err := importOCIManifest(layoutDir, desc, imageRef)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// The ref name annotation is often a bare tag like "1.0", which does not
+// name a repository and is ignored
+func refFromAnnotation(refName string) string {
+	if !strings.ContainsAny(refName, ":/") {
+		return ""
+	}
+	return refName
+}
+
+func importOCIManifest(layoutDir string, desc Descriptor, ref string) (string, error) {
+	if desc.MediaType == MediaTypeIndex || desc.MediaType == mediaTypeDockerList {
+		var index Index
+		if err := readLayoutBlob(layoutDir, desc.Digest, &index); err != nil {
+			return "", err
+		}
+		platformDesc, err := matchPlatform(index.Manifests)
+		if err != nil {
+			return "", err
+		}
+		return importOCIManifest(layoutDir, *platformDesc, ref)
+	}
+
+	var manifest Manifest
+	if err := readLayoutBlob(layoutDir, desc.Digest, &manifest); err != nil {
+		return "", err
+	}
+	blobs := append([]Descriptor{manifest.Config}, manifest.Layers...)
+	blobs = append(blobs, desc)
+	for _, blob := range blobs {
+		if err := copyLayoutBlob(layoutDir, blob); err != nil {
+			return "", err
+		}
+	}
+	if err := registerImage(desc.Digest, ref); err != nil {
+		return "", err
+	}
+	log.Infof("Import image %s with %d layers", desc.Digest, len(manifest.Layers))
+	return desc.Digest, nil
+}
+
+// Pick the manifest built for the current platform from a multi-platform index
+func matchPlatform(manifests []Descriptor) (*Descriptor, error) {
+	for i, desc := range manifests {
+		if desc.Platform == nil ||
+			(desc.Platform.OS == runtime.GOOS && desc.Platform.Architecture == runtime.GOARCH) {
+			return &manifests[i], nil
+		}
+	}
+	return nil, fmt.Errorf("No image for platform %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+func layoutBlobPath(layoutDir, digest string) (string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	return archivePath(layoutDir, filepath.Join("blobs", parts[0], parts[len(parts)-1]))
+}
+
+func readLayoutBlob(layoutDir, digest string, v interface{}) error {
+	if err := validateDigest(digest); err != nil {
+		return err
+	}
+	blob, err := layoutBlobPath(layoutDir, digest)
+	if err != nil {
+		return err
+	}
+	return readJSONFile(blob, v)
+}
+
+func copyLayoutBlob(layoutDir string, desc Descriptor) error {
+	if err := validateDigest(desc.Digest); err != nil {
+		return err
+	}
+	if exist, _ := pathExists(blobPath(desc.Digest)); exist {
+		return nil
+	}
+	blob, err := layoutBlobPath(layoutDir, desc.Digest)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(blob)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return writeVerifiedBlob(file, desc)
+}
+
+func readJSONFile(path string, v interface{}) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
+
+// Return the path of name inside an unpacked image layout or archive.
+// Symlinks of the archive are followed inside dir, never outside of it.
+func archivePath(dir, name string) (string, error) {
+	return archive.ResolveInRoot(dir, name)
+}
+
+// Export an image into an OCI image layout. dst is written as a tarball
+// if it ends with ".tar", otherwise as a directory.
+func ExportOCI(ref, dst string) error {
+	id, manifest, _, err := GetImage(ref)
+	if err != nil {
+		return err
+	}
+
+	var writer layoutWriter
+	if strings.HasSuffix(dst, ".tar") {
+		file, err := os.Create(dst)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writer = &tarLayoutWriter{tw: tar.NewWriter(file)}
+	} else {
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return err
+		}
+		writer = &dirLayoutWriter{dir: dst}
+	}
+
+	manifestContent, err := ReadBlob(id)
+	if err != nil {
+		return err
+	}
+	manifestDesc := Descriptor{
+		MediaType: manifest.MediaType,
+		Digest:    id,
+		Size:      int64(len(manifestContent)),
+	}
+	if manifestDesc.MediaType == "" {
+		manifestDesc.MediaType = MediaTypeManifest
+	}
+	repositories, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	if _, ok := repositories[NormalizeReference(ref)]; ok {
+		manifestDesc.Annotations = map[string]string{
+			AnnotationRefName: NormalizeReference(ref),
+		}
+	}
+
+	if err := writeJSONFile(writer, ociLayoutFile, ociLayout{ImageLayoutVersion: ociLayoutVersion}); err != nil {
+		return err
+	}
+	blobs := append([]Descriptor{manifestDesc, manifest.Config}, manifest.Layers...)
+	for _, blob := range blobs {
+		if err := exportBlob(writer, blob); err != nil {
+			return err
+		}
+	}
+	index := Index{
+		SchemaVersion: manifestSchemaVersion,
+		MediaType:     MediaTypeIndex,
+		Manifests:     []Descriptor{manifestDesc},
+	}
+	if err := writeJSONFile(writer, ociIndexFile, index); err != nil {
+		return err
+	}
+	log.Infof("Export image %s to %s", id, dst)
+	return writer.Close()
+}
+
+func exportBlob(writer layoutWriter, desc Descriptor) error {
+	name := filepath.Join("blobs", digestAlgorithm, digestHex(desc.Digest))
+	return exportBlobAs(writer, desc.Digest, name)
+}
+
+func exportBlobAs(writer layoutWriter, digest, name string) error {
+	blob, err := OpenBlob(digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	info, err := blob.Stat()
+	if err != nil {
+		return err
+	}
+	return writer.WriteFile(name, blob, info.Size())
+}
+
+// layoutWriter writes files of an image layout either into a directory
+// or into a tarball
+type layoutWriter interface {
+	WriteFile(name string, r io.Reader, size int64) error
+	Close() error
+}
+
+type dirLayoutWriter struct {
+	dir string
+}
+
+func (w *dirLayoutWriter) WriteFile(name string, r io.Reader, size int64) error {
+	target := filepath.Join(w.dir, name)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(file, r)
+	return err
+}
+
+func (w *dirLayoutWriter) Close() error {
+	return nil
+}
+
+type tarLayoutWriter struct {
+	tw   *tar.Writer
+	dirs map[string]bool
+}
+
+func (w *tarLayoutWriter) WriteFile(name string, r io.Reader, size int64) error {
+	if w.dirs == nil {
+		w.dirs = map[string]bool{}
+	}
+	// Emit parent directories before the first file inside them
+	var parents []string
+	for dir := filepath.Dir(name); dir != "."; dir = filepath.Dir(dir) {
+		parents = append([]string{dir}, parents...)
+	}
+	for _, dir := range parents {
+		if w.dirs[dir] {
+			continue
+		}
+		w.dirs[dir] = true
+		if err := w.tw.WriteHeader(&tar.Header{Name: dir + "/", Mode: 0755, Typeflag: tar.TypeDir}); err != nil {
+			return err
+		}
+	}
+	if err := w.tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	_, err := io.Copy(w.tw, r)
+	return err
+}
+
+func (w *tarLayoutWriter) Close() error {
+	return w.tw.Close()
+}
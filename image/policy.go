@@ -0,0 +1,128 @@
+package image
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// The policy decides which images may run. Without a policy file every
+// image is accepted. For example, only run images signed by the release
+// key, except images of a local registry:
+//
+//	{
+//	    "default": {"type": "signedBy", "keyPaths": ["/etc/mydocker/keys/release.pub"]},
+//	    "repositories": {
+//	        "localhost:5000/dev": {"type": "insecureAcceptAnything"}
+//	    }
+//	}
+var PolicyPath = "/etc/mydocker/policy.json"
+
+const (
+	PolicyAcceptAnything = "insecureAcceptAnything"
+	PolicyReject         = "reject"
+	PolicySignedBy       = "signedBy"
+)
+
+type Policy struct {
+	Default      PolicyRequirement            `json:"default"`
+	Repositories map[string]PolicyRequirement `json:"repositories,omitempty"`
+}
+
+// A signedBy requirement is met by a valid signature of any of the keys
+type PolicyRequirement struct {
+	Type     string   `json:"type"`
+	KeyPaths []string `json:"keyPaths,omitempty"`
+}
+
+// Return the policy in PolicyPath, or nil if there is none
+func LoadPolicy() (*Policy, error) {
+	content, err := ioutil.ReadFile(PolicyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var policy Policy
+	if err := json.Unmarshal(content, &policy); err != nil {
+		return nil, fmt.Errorf("Load policy %s error: %v", PolicyPath, err)
+	}
+	return &policy, nil
+}
+
+// Check that the policy allows running the image ref. An image given by
+// ID must satisfy the requirements of all its repositories.
+func VerifyImage(ref string) error {
+	policy, err := LoadPolicy()
+	if err != nil || policy == nil {
+		return err
+	}
+	id, err := Resolve(ref)
+	if err != nil {
+		return err
+	}
+	var tags []string
+	if IsTag(ref) {
+		tags = []string{ref}
+	} else if tags, err = GetTags(id); err != nil {
+		return err
+	}
+
+	requirements := map[string]PolicyRequirement{}
+	for _, tag := range tags {
+		name, _ := ParseReference(tag)
+		if requirement, ok := policy.Repositories[name]; ok {
+			requirements[name] = requirement
+		} else {
+			requirements[name] = policy.Default
+		}
+	}
+	if len(requirements) == 0 {
+		requirements[ref] = policy.Default
+	}
+	for name, requirement := range requirements {
+		if err := requirement.check(id); err != nil {
+			return fmt.Errorf("Image %s rejected by policy for %s: %v", ref, name, err)
+		}
+	}
+	return nil
+}
+
+func (requirement *PolicyRequirement) check(id string) error {
+	switch requirement.Type {
+	case PolicyAcceptAnything:
+		return nil
+	case PolicyReject:
+		return fmt.Errorf("images are rejected")
+	case PolicySignedBy:
+	default:
+		return fmt.Errorf("unknown requirement type %q", requirement.Type)
+	}
+
+	var keys []crypto.PublicKey
+	for _, keyPath := range requirement.KeyPaths {
+		key, err := LoadPublicKey(keyPath)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	signatures, err := ImageSignatures(id)
+	if err != nil {
+		return err
+	}
+	if len(signatures) == 0 {
+		return fmt.Errorf("image is not signed")
+	}
+	for _, sig := range signatures {
+		for _, key := range keys {
+			if verifySignature(sig, id, key) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no valid signature by a trusted key")
+}
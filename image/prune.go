@@ -0,0 +1,143 @@
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Blobs which no image references are left behind by interrupted pulls,
+// builds and imports. They are only collected once older than this, a
+// blob written a moment ago may belong to an image not registered yet.
+var pruneGracePeriod = time.Hour
+
+type PruneReport struct {
+	Untagged       []string // References removed from pruned images
+	Deleted        []string // Digests of deleted images and blobs
+	SpaceReclaimed int64    // Bytes freed in blobs and extracted layers
+}
+
+// Remove images without tags, or every image if all is set, except the
+// images in inUse which containers are created from. Blobs and extracted
+// layers are deleted once no remaining image references them.
+func Prune(all bool, inUse map[string]bool) (*PruneReport, error) {
+	report := &PruneReport{}
+	ids, err := ListImageIDs()
+	if err != nil {
+		return nil, err
+	}
+	released := map[string]bool{}
+	for _, id := range ids {
+		if inUse[id] {
+			continue
+		}
+		tags, err := GetTags(id)
+		if err != nil {
+			return report, err
+		}
+		if len(tags) > 0 && !all {
+			continue
+		}
+		manifest, err := GetManifest(id)
+		if err != nil {
+			return report, err
+		}
+		for _, tag := range tags {
+			if err := Untag(tag); err != nil {
+				return report, err
+			}
+			report.Untagged = append(report.Untagged, tag)
+		}
+		if err := os.Remove(path.Join(imagedbDir(), digestHex(id))); err != nil {
+			return report, err
+		}
+		if err := deleteSignatures(id); err != nil {
+			return report, err
+		}
+		released[id] = true
+		released[manifest.Config.Digest] = true
+		for _, layer := range manifest.Layers {
+			released[layer.Digest] = true
+		}
+	}
+
+	if err := pruneBlobs(released, report); err != nil {
+		return report, err
+	}
+	if err := pruneLayerDirs(report); err != nil {
+		return report, err
+	}
+	return report, pruneBuildCache()
+}
+
+// Delete blobs with no reference left. Blobs of the images just removed
+// go at once, other unreferenced blobs after the grace period.
+func pruneBlobs(released map[string]bool, report *PruneReport) error {
+	refs, err := blobRefs()
+	if err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(blobDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	expired := time.Now().Add(-pruneGracePeriod)
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), ".tmp-") {
+			// Leftover of a write that never completed
+			if file.ModTime().Before(expired) {
+				report.SpaceReclaimed += file.Size()
+				os.Remove(path.Join(blobDir(), file.Name()))
+			}
+			continue
+		}
+		digest := digestAlgorithm + ":" + file.Name()
+		if refs[digest] > 0 {
+			continue
+		}
+		if !released[digest] && file.ModTime().After(expired) {
+			continue
+		}
+		freed, err := deleteBlob(digest)
+		report.SpaceReclaimed += freed
+		if err != nil {
+			return err
+		}
+		report.Deleted = append(report.Deleted, digest)
+	}
+	return nil
+}
+
+// Delete layers extracted by any storage driver from a blob which is not
+// referenced any more, for example after its blob was removed by hand
+func pruneLayerDirs(report *PruneReport) error {
+	refs, err := blobRefs()
+	if err != nil {
+		return err
+	}
+	entries, err := filepath.Glob(path.Join(StoreUrl, "layers", "*", "*"))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := path.Base(entry)
+		if len(name) < 64 {
+			continue
+		}
+		if refs[digestAlgorithm+":"+name[:64]] > 0 {
+			continue
+		}
+		freed, err := removeLayerEntry(entry)
+		report.SpaceReclaimed += freed
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const defaultTag = "latest"
+
+func repositoriesPath() string {
+	return path.Join(StoreUrl, "repositories.json")
+}
+
+// Split "name[:tag]" into name and tag, the tag defaults to "latest".
+// A colon before the last slash belongs to a registry host, for example
+// "localhost:5000/busybox".
+func ParseReference(ref string) (string, string) {
+	name, tag := ref, defaultTag
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		name, tag = ref[:i], ref[i+1:]
+	}
+	return name, tag
+}
+
+// Normalize ref into "name:tag"
+func NormalizeReference(ref string) string {
+	name, tag := ParseReference(ref)
+	return name + ":" + tag
+}
+
+func loadRepositories() (map[string]string, error) {
+	repositories := map[string]string{}
+	content, err := ioutil.ReadFile(repositoriesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return repositories, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &repositories); err != nil {
+		return nil, fmt.Errorf("Load %s error: %v", repositoriesPath(), err)
+	}
+	return repositories, nil
+}
+
+func dumpRepositories(repositories map[string]string) error {
+	if err := os.MkdirAll(StoreUrl, 0755); err != nil {
+		return err
+	}
+	content, err := json.Marshal(repositories)
+	if err != nil {
+		return err
+	}
+	// Replace the file atomically, readers never see a partial write
+	tmpPath := repositoriesPath() + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, repositoriesPath())
+}
+
+// Serialize updates of repositories.json between processes
+func lockRepositories() (*os.File, error) {
+	if err := os.MkdirAll(StoreUrl, 0755); err != nil {
+		return nil, err
+	}
+	return lockFile(repositoriesPath() + ".lock")
+}
+
+// Point ref to the image id
+func Tag(id, ref string) error {
+	lock, err := lockRepositories()
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	repositories, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	ref = NormalizeReference(ref)
+	repositories[ref] = id
+	if err := dumpRepositories(repositories); err != nil {
+		return err
+	}
+	log.Infof("Tag %s -> %s", ref, id)
+	return nil
+}
+
+// Return the image ID referenced by ref. Besides "name[:tag]", a full
+// image ID or a unique prefix of its hex digest is also accepted.
+func Resolve(ref string) (string, error) {
+	repositories, err := loadRepositories()
+	if err != nil {
+		return "", err
+	}
+	if id, ok := repositories[NormalizeReference(ref)]; ok {
+		return id, nil
+	}
+
+	prefix := digestHex(ref)
+	if prefix == "" || strings.ContainsAny(prefix, ":/") {
+		return "", fmt.Errorf("No Such Image: %s", ref)
+	}
+	ids, err := ListImageIDs()
+	if err != nil {
+		return "", err
+	}
+	var matches []string
+	for _, id := range ids {
+		if strings.HasPrefix(digestHex(id), prefix) {
+			matches = append(matches, id)
+		}
+	}
+	if len(matches) > 1 {
+		return "", fmt.Errorf("Ambiguous image ID prefix: %s", ref)
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("No Such Image: %s", ref)
+	}
+	return matches[0], nil
+}
+
+// Return all references sorted by name, mapping to image IDs
+func ListReferences() (map[string]string, []string, error) {
+	repositories, err := loadRepositories()
+	if err != nil {
+		return nil, nil, err
+	}
+	var refs []string
+	for ref := range repositories {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return repositories, refs, nil
+}
+
+// Remove the reference ref, the image it points to is kept
+func Untag(ref string) error {
+	lock, err := lockRepositories()
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	repositories, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	ref = NormalizeReference(ref)
+	if _, ok := repositories[ref]; !ok {
+		return fmt.Errorf("No Such Image: %s", ref)
+	}
+	delete(repositories, ref)
+	if err := dumpRepositories(repositories); err != nil {
+		return err
+	}
+	log.Infof("Untag %s", ref)
+	return nil
+}
+
+// Return whether ref is a "name[:tag]" reference rather than an image ID
+func IsTag(ref string) bool {
+	repositories, err := loadRepositories()
+	if err != nil {
+		return false
+	}
+	_, ok := repositories[NormalizeReference(ref)]
+	return ok
+}
+
+// Return sorted references pointing to the image id
+func GetTags(id string) ([]string, error) {
+	repositories, refs, err := ListReferences()
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for _, ref := range refs {
+		if repositories[ref] == id {
+			tags = append(tags, ref)
+		}
+	}
+	return tags, nil
+}
@@ -0,0 +1,636 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Images are pulled from and pushed to registries speaking the OCI
+// distribution API:
+//
+// GET    /v2/                               API check, returns the auth challenge
+// GET    /v2/<name>/manifests/<reference>   fetch a manifest by tag or digest
+// PUT    /v2/<name>/manifests/<tag>         upload a manifest
+// HEAD   /v2/<name>/blobs/<digest>          check whether a blob exists
+// GET    /v2/<name>/blobs/<digest>          fetch a blob
+// POST   /v2/<name>/blobs/uploads/          start a blob upload
+// PATCH  <location>                         upload a chunk of the blob
+// GET    <location>                         query how much has been received
+// PUT    <location>?digest=<digest>         complete the upload
+const (
+	DefaultRegistry = "registry-1.docker.io"
+	uploadChunkSize = 5 << 20
+	uploadRetries   = 3
+	maxManifestSize = 4 << 20
+)
+
+var manifestMediaTypes = []string{
+	MediaTypeManifest,
+	MediaTypeIndex,
+	mediaTypeDockerSchema,
+	mediaTypeDockerList,
+}
+
+// Credentials of a registry, they are sent as basic auth or exchanged
+// for a bearer token depending on the challenge of the registry
+type RegistryAuth struct {
+	Username string
+	Password string
+	// Talk plain HTTP to the registry, always done for localhost
+	Insecure bool
+}
+
+// RemoteReference locates an image in a registry
+type RemoteReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// Parse "[registry/]repository[:tag][@digest]". The first component names
+// a registry if it looks like a host, that is it contains "." or ":" or is
+// "localhost". Official images of the default registry live in "library".
+func ParseRemoteReference(ref string) (*RemoteReference, error) {
+	remote := &RemoteReference{Registry: DefaultRegistry}
+	if i := strings.Index(ref, "@"); i >= 0 {
+		remote.Digest = ref[i+1:]
+		if err := validateDigest(remote.Digest); err != nil {
+			return nil, err
+		}
+		ref = ref[:i]
+	} else {
+		ref, remote.Tag = ParseReference(ref)
+	}
+
+	if i := strings.Index(ref, "/"); i >= 0 {
+		host := ref[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			remote.Registry, ref = host, ref[i+1:]
+		}
+	}
+	if remote.Registry == DefaultRegistry && !strings.Contains(ref, "/") {
+		ref = "library/" + ref
+	}
+	if ref == "" || strings.ToLower(ref) != ref {
+		return nil, fmt.Errorf("Invalid repository name %q", ref)
+	}
+	remote.Repository = ref
+	return remote, nil
+}
+
+func (r *RemoteReference) String() string {
+	if r.Digest != "" {
+		return r.Registry + "/" + r.Repository + "@" + r.Digest
+	}
+	return r.Registry + "/" + r.Repository + ":" + r.Tag
+}
+
+// Tag or digest identifying the manifest in the repository
+func (r *RemoteReference) reference() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+	return r.Tag
+}
+
+// Pull an image from a registry into the store. An image pulled by tag is
+// tagged as ref, an image pulled by digest stays untagged. For a
+// multi-platform image, the manifest of the current platform is pulled.
+// It returns the image ID, which is the digest of the manifest.
+func Pull(ref string, auth *RegistryAuth) (string, error) {
+	remote, err := ParseRemoteReference(ref)
+	if err != nil {
+		return "", err
+	}
+	client, err := newRegistryClient(remote, auth, "pull")
+	if err != nil {
+		return "", err
+	}
+
+	desc, content, err := client.getManifest(remote.reference())
+	if err != nil {
+		return "", err
+	}
+	if desc.MediaType == MediaTypeIndex || desc.MediaType == mediaTypeDockerList {
+		var index Index
+		if err := json.Unmarshal(content, &index); err != nil {
+			return "", fmt.Errorf("Parse index of %s error: %v", remote, err)
+		}
+		platformDesc, err := matchPlatform(index.Manifests)
+		if err != nil {
+			return "", err
+		}
+		if desc, content, err = client.getManifest(platformDesc.Digest); err != nil {
+			return "", err
+		}
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return "", fmt.Errorf("Parse manifest of %s error: %v", remote, err)
+	}
+	for _, blob := range append([]Descriptor{manifest.Config}, manifest.Layers...) {
+		if err := client.pullBlob(blob); err != nil {
+			return "", err
+		}
+	}
+	if err := writeVerifiedBlob(bytes.NewReader(content), *desc); err != nil {
+		return "", err
+	}
+
+	tag := ""
+	if remote.Digest == "" {
+		tag = ref
+	}
+	if err := registerImage(desc.Digest, tag); err != nil {
+		return "", err
+	}
+	log.Infof("Pull %s: %s with %d layers", remote, desc.Digest, len(manifest.Layers))
+	return desc.Digest, nil
+}
+
+// Push the image tagged as ref to the registry named by ref. Blobs
+// already in the repository are skipped, others are uploaded in chunks
+// and an interrupted chunk is resumed from where the registry stopped.
+func Push(ref string, auth *RegistryAuth) error {
+	remote, err := ParseRemoteReference(ref)
+	if err != nil {
+		return err
+	}
+	if remote.Digest != "" {
+		return fmt.Errorf("Push needs a tag, not a digest: %s", ref)
+	}
+	id, manifest, _, err := GetImage(ref)
+	if err != nil {
+		return err
+	}
+	content, err := ReadBlob(id)
+	if err != nil {
+		return err
+	}
+	client, err := newRegistryClient(remote, auth, "pull,push")
+	if err != nil {
+		return err
+	}
+
+	for _, blob := range append(manifest.Layers, manifest.Config) {
+		if err := client.pushBlob(blob); err != nil {
+			return err
+		}
+	}
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = MediaTypeManifest
+	}
+	if err := client.putManifest(remote.Tag, mediaType, content, id); err != nil {
+		return err
+	}
+	log.Infof("Push %s: %s with %d layers", remote, id, len(manifest.Layers))
+	return nil
+}
+
+type registryClient struct {
+	remote        *RemoteReference
+	baseUrl       string
+	auth          *RegistryAuth
+	authorization string
+	client        *http.Client
+}
+
+func newRegistryClient(remote *RemoteReference, auth *RegistryAuth, actions string) (*registryClient, error) {
+	if auth == nil {
+		auth = &RegistryAuth{}
+	}
+	scheme := "https"
+	if auth.Insecure || isLocalhost(remote.Registry) {
+		scheme = "http"
+	}
+	client := &registryClient{
+		remote:  remote,
+		baseUrl: scheme + "://" + remote.Registry,
+		auth:    auth,
+		client:  &http.Client{},
+	}
+	if err := client.authenticate(actions); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func isLocalhost(registry string) bool {
+	host := registry
+	if h, _, err := net.SplitHostPort(registry); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Answer the challenge returned by the API check. A registry asking for
+// basic auth gets the credentials with every request, a registry asking
+// for a bearer token gets a token from its auth server, scoped to the
+// actions ("pull" or "pull,push") on the repository.
+func (c *registryClient) authenticate(actions string) error {
+	resp, err := c.client.Get(c.baseUrl + "/v2/")
+	if err != nil {
+		return fmt.Errorf("Connect to registry %s error: %v", c.remote.Registry, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("Registry %s does not support the distribution API: %s",
+			c.remote.Registry, resp.Status)
+	}
+
+	scheme, params := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.auth.Username == "" {
+			return fmt.Errorf("Registry %s requires a username and password", c.remote.Registry)
+		}
+		c.authorization = "Basic " + basicCredentials(c.auth)
+	case "bearer":
+		token, err := c.fetchToken(params, "repository:"+c.remote.Repository+":"+actions)
+		if err != nil {
+			return err
+		}
+		c.authorization = "Bearer " + token
+	default:
+		return fmt.Errorf("Unsupported auth scheme %q of registry %s", scheme, c.remote.Registry)
+	}
+	return nil
+}
+
+func basicCredentials(auth *RegistryAuth) string {
+	return base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password))
+}
+
+// Split `Bearer realm="https://auth.io/token",service="registry.io"` into
+// the scheme and its parameters, quoted values may contain commas
+func parseChallenge(header string) (string, map[string]string) {
+	params := map[string]string{}
+	header = strings.TrimSpace(header)
+	i := strings.Index(header, " ")
+	if i < 0 {
+		return header, params
+	}
+	scheme, rest := header[:i], header[i+1:]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if end := strings.Index(rest, ","); end >= 0 {
+			value, rest = rest[:end], rest[end:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+	}
+	return scheme, params
+}
+
+// Request a bearer token from the auth server named in the challenge,
+// credentials are sent if any, anonymous tokens allow pulling public images
+func (c *registryClient) fetchToken(params map[string]string, scope string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("Invalid token realm %q of registry %s", params["realm"], c.remote.Registry)
+	}
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.auth.Username != "" {
+		req.SetBasicAuth(c.auth.Username, c.auth.Password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Fetch token from %s error: %v", realm.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Fetch token from %s error: %s", realm.Host, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("Parse token from %s error: %v", realm.Host, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return "", fmt.Errorf("No token in response of %s", realm.Host)
+	}
+	return token.Token, nil
+}
+
+// Return the URL of an endpoint under the repository, like "blobs/<digest>"
+func (c *registryClient) url(endpoint string) string {
+	return c.baseUrl + "/v2/" + c.remote.Repository + "/" + endpoint
+}
+
+func (c *registryClient) do(method, rawUrl string, header http.Header, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, rawUrl, reader)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if c.authorization != "" {
+		req.Header.Set("Authorization", c.authorization)
+	}
+	return c.client.Do(req)
+}
+
+// Build an error from an unexpected response, registries describe
+// failures as {"errors": [{"code": "...", "message": "..."}]}
+func responseError(resp *http.Response) error {
+	var body struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(content, &body) == nil && len(body.Errors) > 0 {
+		var messages []string
+		for _, e := range body.Errors {
+			messages = append(messages, e.Code+": "+e.Message)
+		}
+		return fmt.Errorf("%s %s: %s (%s)", resp.Request.Method, resp.Request.URL.Path,
+			resp.Status, strings.Join(messages, "; "))
+	}
+	return fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status)
+}
+
+// Fetch a manifest or index and check it against the requested digest
+// and the digest announced by the registry
+func (c *registryClient) getManifest(reference string) (*Descriptor, []byte, error) {
+	header := http.Header{"Accept": []string{strings.Join(manifestMediaTypes, ", ")}}
+	resp, err := c.do(http.MethodGet, c.url("manifests/"+reference), header, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("Fetch manifest %s error: %v", reference, responseError(resp))
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(content) > maxManifestSize {
+		return nil, nil, fmt.Errorf("Manifest %s is larger than %d bytes", reference, maxManifestSize)
+	}
+
+	hash := sha256.Sum256(content)
+	desc := &Descriptor{
+		Digest: digestAlgorithm + ":" + hex.EncodeToString(hash[:]),
+		Size:   int64(len(content)),
+	}
+	if strings.HasPrefix(reference, digestAlgorithm+":") && reference != desc.Digest {
+		return nil, nil, fmt.Errorf("Digest mismatch of manifest: expected %s, got %s", reference, desc.Digest)
+	}
+	if announced := resp.Header.Get("Docker-Content-Digest"); announced != "" && announced != desc.Digest {
+		return nil, nil, fmt.Errorf("Digest mismatch of manifest %s: registry announced %s, got %s",
+			reference, announced, desc.Digest)
+	}
+
+	desc.MediaType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	var versioned struct {
+		MediaType string `json:"mediaType"`
+	}
+	if json.Unmarshal(content, &versioned) == nil && versioned.MediaType != "" {
+		desc.MediaType = versioned.MediaType
+	}
+	return desc, content, nil
+}
+
+func (c *registryClient) pullBlob(desc Descriptor) error {
+	if err := validateDigest(desc.Digest); err != nil {
+		return err
+	}
+	if exist, _ := pathExists(blobPath(desc.Digest)); exist {
+		log.Infof("%s: already exists", ShortID(desc.Digest))
+		return nil
+	}
+	resp, err := c.do(http.MethodGet, c.url("blobs/"+desc.Digest), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Fetch blob %s error: %v", desc.Digest, responseError(resp))
+	}
+	log.Infof("%s: pull %d bytes", ShortID(desc.Digest), desc.Size)
+	if err := writeVerifiedBlob(resp.Body, desc); err != nil {
+		return fmt.Errorf("Pull blob %s error: %v", desc.Digest, err)
+	}
+	return nil
+}
+
+func (c *registryClient) pushBlob(desc Descriptor) error {
+	resp, err := c.do(http.MethodHead, c.url("blobs/"+desc.Digest), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		log.Infof("%s: already exists", ShortID(desc.Digest))
+		return nil
+	}
+
+	blob, err := OpenBlob(desc.Digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	info, err := blob.Stat()
+	if err != nil {
+		return err
+	}
+
+	resp, err = c.do(http.MethodPost, c.url("blobs/uploads/"), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("Start upload of %s error: %v", desc.Digest, responseError(resp))
+	}
+	location, err := uploadLocation(resp)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("%s: push %d bytes", ShortID(desc.Digest), info.Size())
+	for offset := int64(0); offset < info.Size(); {
+		if location, offset, err = c.uploadChunk(location, blob, offset, info.Size()); err != nil {
+			return fmt.Errorf("Upload blob %s error: %v", desc.Digest, err)
+		}
+	}
+
+	completeUrl, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	query := completeUrl.Query()
+	query.Set("digest", desc.Digest)
+	completeUrl.RawQuery = query.Encode()
+	resp, err = c.do(http.MethodPut, completeUrl.String(), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("Complete upload of %s error: %v", desc.Digest, responseError(resp))
+	}
+	return nil
+}
+
+// Upload the chunk of blob starting at offset, and return the location
+// and offset of the next chunk. If the upload fails, the registry is asked
+// how much it received so that the next attempt resumes from there.
+func (c *registryClient) uploadChunk(location string, blob *os.File, offset, size int64) (string, int64, error) {
+	var lastErr error
+	for attempt := 0; attempt < uploadRetries; attempt++ {
+		end := offset + uploadChunkSize
+		if end > size {
+			end = size
+		}
+		chunk := make([]byte, end-offset)
+		if _, err := blob.ReadAt(chunk, offset); err != nil {
+			return "", 0, err
+		}
+		header := http.Header{
+			"Content-Type":  []string{"application/octet-stream"},
+			"Content-Range": []string{fmt.Sprintf("%d-%d", offset, end-1)},
+		}
+		resp, err := c.do(http.MethodPatch, location, header, chunk)
+		if err == nil {
+			if resp.StatusCode == http.StatusAccepted {
+				resp.Body.Close()
+				next, err := uploadLocation(resp)
+				return next, end, err
+			}
+			err = responseError(resp)
+			resp.Body.Close()
+		}
+		lastErr = err
+
+		received, next, err := c.uploadStatus(location)
+		if err != nil {
+			log.Warnf("Query upload status error: %v", err)
+			continue
+		}
+		log.Warnf("Upload chunk %d-%d error: %v, resume at %d", offset, end-1, lastErr, received)
+		location = next
+		if received != offset {
+			return location, received, nil
+		}
+	}
+	return "", 0, lastErr
+}
+
+// Return how many bytes of an upload the registry has received, from the
+// Range header "0-<last byte>" of the upload status
+func (c *registryClient) uploadStatus(location string) (int64, string, error) {
+	resp, err := c.do(http.MethodGet, location, nil, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return 0, "", responseError(resp)
+	}
+	next, err := uploadLocation(resp)
+	if err != nil {
+		return 0, "", err
+	}
+	byteRange := resp.Header.Get("Range")
+	if byteRange == "" {
+		return 0, next, nil
+	}
+	i := strings.LastIndex(byteRange, "-")
+	last, err := strconv.ParseInt(byteRange[i+1:], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("Invalid upload range %q", byteRange)
+	}
+	return last + 1, next, nil
+}
+
+// Return the absolute URL of the Location header, which may be relative
+func uploadLocation(resp *http.Response) (string, error) {
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return resp.Request.URL.String(), nil
+	}
+	next, err := resp.Request.URL.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("Invalid upload location %q", location)
+	}
+	return next.String(), nil
+}
+
+func (c *registryClient) putManifest(tag, mediaType string, content []byte, digest string) error {
+	header := http.Header{"Content-Type": []string{mediaType}}
+	resp, err := c.do(http.MethodPut, c.url("manifests/"+tag), header, content)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("Upload manifest error: %v", responseError(resp))
+	}
+	if announced := resp.Header.Get("Docker-Content-Digest"); announced != "" && announced != digest {
+		return fmt.Errorf("Digest mismatch of manifest: registry stored %s, expected %s", announced, digest)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A registry stand-in keeping blobs and manifests in memory. It asks for
+// a bearer token, and drops the second half of the first chunk uploaded
+// to exercise resumed uploads.
+type fakeRegistry struct {
+	sync.Mutex
+	server    *httptest.Server
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	uploads   map[string][]byte
+	failed    bool
+}
+
+func newFakeRegistry() *fakeRegistry {
+	registry := &fakeRegistry{
+		blobs:     map[string][]byte{},
+		manifests: map[string][]byte{},
+		uploads:   map[string][]byte{},
+	}
+	registry.server = httptest.NewServer(registry)
+	return registry
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.Lock()
+	defer r.Unlock()
+
+	if req.URL.Path == "/token" {
+		if user, password, _ := req.BasicAuth(); user != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token": "t0ken"}`)
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer t0ken" {
+		w.Header().Set("WWW-Authenticate",
+			fmt.Sprintf(`Bearer realm="%s/token",service="fake",scope="repository:test:pull,push"`, r.server.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if req.URL.Path == "/v2/" {
+		return
+	}
+
+	body, _ := ioutil.ReadAll(req.Body)
+	path := strings.TrimPrefix(req.URL.Path, "/v2/test/")
+	switch {
+	case strings.HasPrefix(path, "manifests/"):
+		ref := strings.TrimPrefix(path, "manifests/")
+		if req.Method == http.MethodPut {
+			r.manifests[ref] = body
+			r.manifests[fakeDigest(body)] = body
+			w.Header().Set("Docker-Content-Digest", fakeDigest(body))
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		manifest, ok := r.manifests[ref]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", MediaTypeManifest)
+		w.Write(manifest)
+	case strings.HasPrefix(path, "blobs/uploads/"):
+		id := strings.TrimPrefix(path, "blobs/uploads/")
+		switch req.Method {
+		case http.MethodPost:
+			id = fmt.Sprintf("%d", len(r.uploads)+1)
+			r.uploads[id] = nil
+		case http.MethodPatch:
+			if !r.failed {
+				r.failed = true
+				r.uploads[id] = append(r.uploads[id], body[:len(body)/2]...)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			r.uploads[id] = append(r.uploads[id], body...)
+		case http.MethodGet:
+			w.Header().Set("Range", fmt.Sprintf("0-%d", len(r.uploads[id])-1))
+			w.Header().Set("Location", "/v2/test/blobs/uploads/"+id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case http.MethodPut:
+			content := append(r.uploads[id], body...)
+			if fakeDigest(content) != req.URL.Query().Get("digest") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			r.blobs[fakeDigest(content)] = content
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Header().Set("Location", "/v2/test/blobs/uploads/"+id)
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasPrefix(path, "blobs/"):
+		blob, ok := r.blobs[strings.TrimPrefix(path, "blobs/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(blob)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func fakeDigest(content []byte) string {
+	hash := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(hash[:])
+}
+
+func TestParseRemoteReference(t *testing.T) {
+	cases := map[string]string{
+		"busybox":                     "registry-1.docker.io/library/busybox:latest",
+		"user/app:1.0":                "registry-1.docker.io/user/app:1.0",
+		"localhost:5000/busybox":      "localhost:5000/busybox:latest",
+		"registry.io/team/app:stable": "registry.io/team/app:stable",
+	}
+	for ref, expected := range cases {
+		remote, err := ParseRemoteReference(ref)
+		if err != nil || remote.String() != expected {
+			t.Errorf("ParseRemoteReference(%q) = %v, %v", ref, remote, err)
+		}
+	}
+}
+
+func TestPushPull(t *testing.T) {
+	defer setupStore(t)()
+	registry := newFakeRegistry()
+	defer registry.server.Close()
+	auth := &RegistryAuth{Username: "user", Password: "secret"}
+
+	ref := strings.TrimPrefix(registry.server.URL, "http://") + "/test:1.0"
+	id, err := CreateImage(ref, NewImage(), []*Layer{testLayer(t)})
+	if err != nil {
+		t.Fatalf("create image %v", err)
+	}
+	if err := Push(ref, &RegistryAuth{Username: "user", Password: "wrong"}); err == nil {
+		t.Error("push with wrong credentials succeeded")
+	}
+	if err := Push(ref, auth); err != nil {
+		t.Fatalf("push %v", err)
+	}
+	if !registry.failed || len(registry.blobs) != 2 {
+		t.Errorf("unexpected upload: failed %v, %d blobs", registry.failed, len(registry.blobs))
+	}
+
+	// Pull into an empty store
+	defer setupStore(t)()
+	pulled, err := Pull(ref, auth)
+	if err != nil {
+		t.Fatalf("pull %v", err)
+	}
+	if resolved, err := Resolve(ref); pulled != id || resolved != id {
+		t.Errorf("pulled %s, resolved %s, %v, expected %s", pulled, resolved, err, id)
+	}
+	if _, err := Pull(strings.Split(ref, ":1.0")[0]+"@"+id, auth); err != nil {
+		t.Errorf("pull by digest %v", err)
+	}
+
+	// A blob not matching its digest is rejected
+	manifest, _ := GetManifest(id)
+	defer setupStore(t)()
+	for digest := range registry.blobs {
+		if digest != manifest.Config.Digest {
+			registry.blobs[digest] = []byte("corrupted")
+		}
+	}
+	if _, err := Pull(ref, auth); err == nil {
+		t.Error("pull of a corrupted layer succeeded")
+	}
+}
@@ -0,0 +1,198 @@
+package image
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+const (
+	SignatureEd25519 = "ed25519"
+	SignatureECDSA   = "ecdsa-sha256"
+)
+
+// Signatures are detached from images, every signature covers the digest
+// of the image manifest and is stored as
+// "<StoreUrl>/signatures/<manifest hex>/<key id>.json"
+type Signature struct {
+	Digest    string `json:"digest"`    // Manifest digest, that is the image ID
+	KeyId     string `json:"keyId"`     // Digest of the public key
+	Algorithm string `json:"algorithm"` // ed25519 or ecdsa-sha256
+	Signature []byte `json:"signature"`
+	Created   string `json:"created"`
+}
+
+func signatureDir(id string) string {
+	return path.Join(StoreUrl, "signatures", digestHex(id))
+}
+
+// The signed payload names its purpose, so that the key does not sign
+// a bare digest which could mean something else elsewhere
+func signaturePayload(digest string) []byte {
+	return []byte("mydocker image signature v1\n" + digest)
+}
+
+// Sign the manifest of an image with the private key in keyPath, a PEM
+// encoded ed25519 or ECDSA key. It returns the path of the signature.
+func SignImage(ref, keyPath string) (string, error) {
+	id, err := Resolve(ref)
+	if err != nil {
+		return "", err
+	}
+	key, err := LoadPrivateKey(keyPath)
+	if err != nil {
+		return "", err
+	}
+	keyId, err := publicKeyId(key.Public())
+	if err != nil {
+		return "", err
+	}
+
+	sig := &Signature{
+		Digest:  id,
+		KeyId:   keyId,
+		Created: time.Now().UTC().Format(time.RFC3339),
+	}
+	payload := signaturePayload(id)
+	switch key.(type) {
+	case ed25519.PrivateKey:
+		sig.Algorithm = SignatureEd25519
+		sig.Signature, err = key.Sign(rand.Reader, payload, crypto.Hash(0))
+	case *ecdsa.PrivateKey:
+		sig.Algorithm = SignatureECDSA
+		hash := sha256.Sum256(payload)
+		sig.Signature, err = key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Sign image %s error: %v", ref, err)
+	}
+
+	if err := os.MkdirAll(signatureDir(id), 0755); err != nil {
+		return "", err
+	}
+	content, err := json.Marshal(sig)
+	if err != nil {
+		return "", err
+	}
+	sigPath := path.Join(signatureDir(id), digestHex(keyId)+".json")
+	if err := ioutil.WriteFile(sigPath, content, 0644); err != nil {
+		return "", err
+	}
+	return sigPath, nil
+}
+
+// Return the signatures recorded for an image
+func ImageSignatures(id string) ([]*Signature, error) {
+	files, err := filepath.Glob(path.Join(signatureDir(id), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var signatures []*Signature
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var sig Signature
+		if err := json.Unmarshal(content, &sig); err != nil {
+			return nil, fmt.Errorf("Read signature %s error: %v", file, err)
+		}
+		signatures = append(signatures, &sig)
+	}
+	return signatures, nil
+}
+
+func deleteSignatures(id string) error {
+	return os.RemoveAll(signatureDir(id))
+}
+
+// Check that sig is a signature of the image id by the public key
+func verifySignature(sig *Signature, id string, key crypto.PublicKey) bool {
+	if sig.Digest != id {
+		return false
+	}
+	payload := signaturePayload(id)
+	switch pub := key.(type) {
+	case ed25519.PublicKey:
+		return sig.Algorithm == SignatureEd25519 && ed25519.Verify(pub, payload, sig.Signature)
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(payload)
+		return sig.Algorithm == SignatureECDSA && ecdsa.VerifyASN1(pub, hash[:], sig.Signature)
+	}
+	return false
+}
+
+// Load a PEM encoded private key, PKCS #8 for ed25519 and ECDSA keys, or
+// SEC 1 as written by `openssl ecparam -genkey`
+func LoadPrivateKey(keyPath string) (crypto.Signer, error) {
+	block, err := readPEM(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "EC PRIVATE KEY" {
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Parse private key %s error: %v", keyPath, err)
+	}
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("Private key %s is neither ed25519 nor ECDSA", keyPath)
+}
+
+// Load a PEM encoded public key in PKIX form, as written by
+// `openssl pkey -pubout`
+func LoadPublicKey(keyPath string) (crypto.PublicKey, error) {
+	block, err := readPEM(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Parse public key %s error: %v", keyPath, err)
+	}
+	switch key.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("Public key %s is neither ed25519 nor ECDSA", keyPath)
+}
+
+func readPEM(keyPath string) (*pem.Block, error) {
+	content, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data in %s", keyPath)
+	}
+	return block, nil
+}
+
+// "sha256:<hex>" digest of the DER encoded public key
+func publicKeyId(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(der)
+	return digestAlgorithm + ":" + hex.EncodeToString(hash[:]), nil
+}
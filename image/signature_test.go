@@ -0,0 +1,106 @@
+package image
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Write a key pair as PEM files and return their paths
+func writeKeyPair(t *testing.T, dir, name string, key crypto.Signer) (string, string) {
+	privateDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePath := filepath.Join(dir, name+".key")
+	publicPath := filepath.Join(dir, name+".pub")
+	ioutil.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600)
+	ioutil.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644)
+	return privatePath, publicPath
+}
+
+func TestSignAndVerify(t *testing.T) {
+	defer setupStore(t)()
+	keyDir, _ := ioutil.TempDir(StoreUrl, "keys")
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	edPrivate, edPublic := writeKeyPair(t, keyDir, "release", edKey)
+	ecPrivate, ecPublic := writeKeyPair(t, keyDir, "ecdsa", ecKey)
+	otherPrivate, _ := writeKeyPair(t, keyDir, "other", otherKey)
+
+	layer := testLayer(t)
+	if _, err := CreateImage("signed", NewImage(), []*Layer{layer}); err != nil {
+		t.Fatalf("create image %v", err)
+	}
+	img := NewImage()
+	img.Author = "ecdsa"
+	if _, err := CreateImage("ecdsa", img, []*Layer{layer}); err != nil {
+		t.Fatalf("create image %v", err)
+	}
+	img.Author = "unsigned"
+	if _, err := CreateImage("unsigned", img, []*Layer{layer}); err != nil {
+		t.Fatalf("create image %v", err)
+	}
+	img.Author = "other"
+	if _, err := CreateImage("localhost:5000/dev", img, []*Layer{layer}); err != nil {
+		t.Fatalf("create image %v", err)
+	}
+	for ref, key := range map[string]string{"signed": edPrivate, "ecdsa": ecPrivate, "unsigned": otherPrivate} {
+		if _, err := SignImage(ref, key); err != nil {
+			t.Fatalf("sign %s %v", ref, err)
+		}
+	}
+
+	// Without policy every image runs
+	defer func(policyPath string) { PolicyPath = policyPath }(PolicyPath)
+	PolicyPath = filepath.Join(StoreUrl, "policy.json")
+	if err := VerifyImage("unsigned"); err != nil {
+		t.Errorf("verify without policy %v", err)
+	}
+
+	ioutil.WriteFile(PolicyPath, []byte(fmt.Sprintf(`{
+		"default": {"type": "signedBy", "keyPaths": [%q, %q]},
+		"repositories": {"localhost:5000/dev": {"type": "insecureAcceptAnything"}}
+	}`, edPublic, ecPublic)), 0644)
+	for _, ref := range []string{"signed", "ecdsa", "localhost:5000/dev"} {
+		if err := VerifyImage(ref); err != nil {
+			t.Errorf("verify %s %v", ref, err)
+		}
+	}
+	if err := VerifyImage("unsigned"); err == nil || !strings.Contains(err.Error(), "no valid signature") {
+		t.Errorf("image signed by an untrusted key accepted: %v", err)
+	}
+	// A signature is bound to the manifest digest it was made for
+	signedId, _ := Resolve("signed")
+	unsignedId, _ := Resolve("unsigned")
+	signatures, _ := ImageSignatures(signedId)
+	copied := *signatures[0]
+	copied.Digest = unsignedId
+	if verifySignature(&copied, unsignedId, edKey.Public()) {
+		t.Errorf("signature of %s verifies %s", signedId, unsignedId)
+	}
+	// An image ID must satisfy the requirements of all its tags
+	if err := Tag(unsignedId, "localhost:5000/dev:unsigned"); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyImage("localhost:5000/dev:unsigned"); err != nil {
+		t.Errorf("verify by accepted repository %v", err)
+	}
+	if err := VerifyImage(unsignedId); err == nil {
+		t.Errorf("unsigned image accepted by ID")
+	}
+}
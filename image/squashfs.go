@@ -0,0 +1,145 @@
+package image
+
+import (
+	"../archive"
+	"../misc"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// A layer converted to squashfs stays compressed on disk and is loop
+// mounted read-only instead of being extracted, whiteouts are already in
+// the format of the storage driver:
+//
+// layers/<driver>/<hex>.squashfs   compressed layer
+// layers/<driver>/<hex>.mnt/       mount point used as lower dir
+func squashfsPath(storageDriver, digest string) string {
+	return layerDir(storageDriver, digest) + ".squashfs"
+}
+
+func squashfsMountPath(storageDriver, digest string) string {
+	return layerDir(storageDriver, digest) + ".mnt"
+}
+
+// Convert the layers of an image into squashfs files for storageDriver.
+// The extracted copy of a layer is removed once converted, unless an
+// image in inUse, which containers are created from, has the layer. It
+// is removed by a conversion after these containers are gone.
+func ConvertSquashfs(ref, storageDriver string, inUse map[string]bool) error {
+	if _, err := exec.LookPath("mksquashfs"); err != nil {
+		return fmt.Errorf("mksquashfs not found, install squashfs-tools")
+	}
+	_, manifest, _, err := GetImage(ref)
+	if err != nil {
+		return err
+	}
+	busy := map[string]bool{}
+	for id := range inUse {
+		used, err := GetManifest(id)
+		if err != nil {
+			continue
+		}
+		for _, layer := range used.Layers {
+			busy[layer.Digest] = true
+		}
+	}
+
+	for _, layer := range manifest.Layers {
+		if err := convertLayer(layer, storageDriver); err != nil {
+			return err
+		}
+		if busy[layer.Digest] {
+			log.Warnf("Layer %s is used by a container, keep its extracted copy", ShortID(layer.Digest))
+			continue
+		}
+		dir := layerDir(storageDriver, layer.Digest)
+		// Drop the marker first, a partly removed copy is never used
+		if err := os.Remove(dir + ".done"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		log.Infof("$ rm -rf %s", dir)
+	}
+	return nil
+}
+
+func convertLayer(layer Descriptor, storageDriver string) error {
+	target := squashfsPath(storageDriver, layer.Digest)
+	if exist, err := pathExists(target); err != nil || exist {
+		return err
+	}
+	// The extracted layer has its whiteouts converted already
+	dir := layerDir(storageDriver, layer.Digest)
+	if _, err := extractLayer(layer, storageDriver); err != nil {
+		return err
+	}
+	lock, err := lockFile(dir + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if exist, err := pathExists(target); err != nil || exist {
+		return err
+	}
+
+	tmpPath := target + ".tmp"
+	os.Remove(tmpPath)
+	defer os.Remove(tmpPath)
+	output, err := exec.Command("mksquashfs", dir, tmpPath, "-noappend", "-no-progress", "-quiet").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Convert layer %s error: %v %s", layer.Digest, err, strings.TrimSpace(string(output)))
+	}
+	log.Infof("$ mksquashfs %s %s", dir, target)
+	return os.Rename(tmpPath, target)
+}
+
+// Return the mount point of a layer converted to squashfs, mounting it if
+// needed. A layer which is not converted returns an empty path.
+func mountSquashfs(layer Descriptor, storageDriver string) (string, error) {
+	source := squashfsPath(storageDriver, layer.Digest)
+	if exist, err := pathExists(source); err != nil || !exist {
+		return "", err
+	}
+	mnt := squashfsMountPath(storageDriver, layer.Digest)
+	if mounted, err := misc.IsMounted(mnt); err != nil || mounted {
+		return mnt, err
+	}
+
+	lock, err := lockFile(layerDir(storageDriver, layer.Digest) + ".lock")
+	if err != nil {
+		return "", err
+	}
+	defer lock.Close()
+	if mounted, err := misc.IsMounted(mnt); err != nil || mounted {
+		return mnt, err
+	}
+	if err := os.MkdirAll(mnt, 0755); err != nil {
+		return "", err
+	}
+	// mount sets up the loop device, which is released on unmount
+	output, err := exec.Command("mount", "-t", "squashfs", "-o", "loop,ro", source, mnt).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("Mount %s error: %v %s", source, err, strings.TrimSpace(string(output)))
+	}
+	log.Infof("$ mount -t squashfs -o loop,ro %s %s", source, mnt)
+	return mnt, nil
+}
+
+// Remove an entry under layers/<driver>, unmounting it first if it is a
+// squashfs mount point. It returns the bytes freed.
+func removeLayerEntry(entry string) (int64, error) {
+	if mounted, _ := misc.IsMounted(entry); mounted {
+		if err := syscall.Unmount(entry, syscall.MNT_DETACH); err != nil {
+			return 0, fmt.Errorf("Unmount %s error: %v", entry, err)
+		}
+		log.Infof("$ umount %s", entry)
+	}
+	size := archive.DiskUsage(entry)
+	return size, os.RemoveAll(entry)
+}
@@ -0,0 +1,183 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// Images are kept in a content-addressable store. Every blob (layer tarball,
+// image config and manifest) is saved under its sha256 digest, so that a
+// layer shared by several images is stored and extracted only once.
+//
+// /root/image
+// |-- blobs/sha256/<hex>              layer tarballs, configs and manifests
+// |-- imagedb/<hex>                   one entry per image manifest
+// |-- layers/<driver>/<hex>/          extracted layers used as lower dirs
+// |-- layers/<driver>/<hex>.done      marker written once extraction completes
+// |-- layers/<driver>/<hex>.lock      serializes concurrent extractions
+// |-- layers/<driver>/<hex>.squashfs  layer converted to squashfs
+// |-- layers/<driver>/<hex>.mnt/      where the squashfs layer is mounted
+// |-- signatures/<hex>/<key>.json     detached signatures of image manifests
+// `-- repositories.json               {"busybox:latest": "sha256:<manifest>"}
+var (
+	StoreUrl = "/root/image"
+)
+
+const digestAlgorithm = "sha256"
+
+func blobDir() string {
+	return path.Join(StoreUrl, "blobs", digestAlgorithm)
+}
+
+func imagedbDir() string {
+	return path.Join(StoreUrl, "imagedb")
+}
+
+func blobPath(digest string) string {
+	return path.Join(blobDir(), digestHex(digest))
+}
+
+// "sha256:8a3d..." -> "8a3d..."
+func digestHex(digest string) string {
+	return strings.TrimPrefix(digest, digestAlgorithm+":")
+}
+
+// Truncate an image ID or digest for display, "sha256:8a3d5b7c..." -> "8a3d5b7c9e1f"
+func ShortID(digest string) string {
+	hexPart := digestHex(digest)
+	if len(hexPart) > 12 {
+		return hexPart[:12]
+	}
+	return hexPart
+}
+
+func validateDigest(digest string) error {
+	hexPart := digestHex(digest)
+	if !strings.HasPrefix(digest, digestAlgorithm+":") || len(hexPart) != sha256.Size*2 {
+		return fmt.Errorf("Invalid digest %s", digest)
+	}
+	if _, err := hex.DecodeString(hexPart); err != nil {
+		return fmt.Errorf("Invalid digest %s", digest)
+	}
+	return nil
+}
+
+// Take an exclusive lock on the file at lockPath, blocking until other
+// processes release it. The lock is released by closing the returned file,
+// or when the process exits.
+func lockFile(lockPath string) (*os.File, error) {
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Lock %s error: %v", lockPath, err)
+	}
+	return file, nil
+}
+
+// Copy r into the blob store and return its descriptor
+func WriteBlob(r io.Reader, mediaType string) (*Descriptor, error) {
+	if err := os.MkdirAll(blobDir(), 0755); err != nil {
+		return nil, err
+	}
+
+	// Write to a temporary file first, the digest is only known
+	// after the whole content has been read
+	tmpFile, err := ioutil.TempFile(blobDir(), ".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmpFile, hash), r)
+	tmpFile.Close()
+	if err != nil {
+		return nil, fmt.Errorf("Write blob error: %v", err)
+	}
+
+	desc := &Descriptor{
+		MediaType: mediaType,
+		Digest:    digestAlgorithm + ":" + hex.EncodeToString(hash.Sum(nil)),
+		Size:      size,
+	}
+	if exist, _ := pathExists(blobPath(desc.Digest)); exist {
+		return desc, nil
+	}
+	if err := os.Rename(tmpFile.Name(), blobPath(desc.Digest)); err != nil {
+		return nil, err
+	}
+	log.Infof("$ sha256sum > %s", blobPath(desc.Digest))
+	return desc, nil
+}
+
+// Copy r into the blob store, and check that the content matches the
+// digest and size recorded in desc
+func writeVerifiedBlob(r io.Reader, desc Descriptor) error {
+	if err := validateDigest(desc.Digest); err != nil {
+		return err
+	}
+	written, err := WriteBlob(r, desc.MediaType)
+	if err != nil {
+		return err
+	}
+	if written.Digest != desc.Digest {
+		return fmt.Errorf("Digest mismatch: expected %s, got %s", desc.Digest, written.Digest)
+	}
+	if desc.Size != 0 && written.Size != desc.Size {
+		return fmt.Errorf("Size mismatch of %s: expected %d, got %d", desc.Digest, desc.Size, written.Size)
+	}
+	return nil
+}
+
+func WriteJSONBlob(v interface{}, mediaType string) (*Descriptor, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return WriteBlob(strings.NewReader(string(content)), mediaType)
+}
+
+func ReadBlob(digest string) ([]byte, error) {
+	if err := validateDigest(digest); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(blobPath(digest))
+}
+
+func OpenBlob(digest string) (*os.File, error) {
+	if err := validateDigest(digest); err != nil {
+		return nil, err
+	}
+	return os.Open(blobPath(digest))
+}
+
+func readJSONBlob(digest string, v interface{}) error {
+	content, err := ReadBlob(digest)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, v)
+}
+
+func pathExists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
@@ -0,0 +1,66 @@
+package image
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Layer tarballs mark files deleted from lower layers with whiteout files,
+// which AUFS understands natively:
+//
+// .wh.<name>      <name> is removed from lower layers
+// .wh..wh..opq    the directory is opaque, lower layers' entries are hidden
+//
+// Overlayfs represents the same information differently, a removed file
+// is a character device with 0/0 device number and an opaque directory
+// carries the "trusted.overlay.opaque=y" extended attribute.
+const (
+	WhiteoutPrefix = ".wh."
+	WhiteoutOpaque = WhiteoutPrefix + WhiteoutPrefix + ".opq"
+	overlayOpaque  = "trusted.overlay.opaque"
+)
+
+// Return whether the storage driver needs whiteout files converted
+// before an extracted layer can be used as its lower dir
+func needWhiteoutConversion(storageDriver string) bool {
+	return storageDriver == "overlay"
+}
+
+// Convert AUFS style whiteout files under dir into overlayfs whiteouts
+func convertOverlayWhiteouts(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := info.Name()
+		if !strings.HasPrefix(name, WhiteoutPrefix) {
+			return nil
+		}
+		parent := filepath.Dir(path)
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+
+		if name == WhiteoutOpaque {
+			if err := syscall.Setxattr(parent, overlayOpaque, []byte("y"), 0); err != nil {
+				return fmt.Errorf("Set opaque xattr on %s error: %v", parent, err)
+			}
+			log.Debugf("$ setfattr -n %s -v y %s", overlayOpaque, parent)
+			return nil
+		}
+
+		target := filepath.Join(parent, strings.TrimPrefix(name, WhiteoutPrefix))
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+		if err := syscall.Mknod(target, syscall.S_IFCHR, 0); err != nil {
+			return fmt.Errorf("Create whiteout %s error: %v", target, err)
+		}
+		log.Debugf("$ mknod %s c 0 0", target)
+		return nil
+	})
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"./container"
+	"./image"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func listImages(all bool) error {
+	infos, err := image.ListImages(all)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\n")
+	for _, info := range infos {
+		tags := info.RepoTags
+		if len(tags) == 0 {
+			tags = []string{"<none>:<none>"}
+		}
+		for _, ref := range tags {
+			i := strings.LastIndex(ref, ":")
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+				ref[:i],
+				ref[i+1:],
+				image.ShortID(info.Id),
+				formatCreated(info.Created),
+				humanSize(info.Size))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+		return err
+	}
+	return nil
+}
+
+// Remove a reference, the image is deleted when it loses its last
+// reference, unless a container still uses it
+func removeImage(ref string) error {
+	id, err := image.Resolve(ref)
+	if err != nil {
+		return err
+	}
+	tags, err := image.GetTags(id)
+	if err != nil {
+		return err
+	}
+	if image.IsTag(ref) && len(tags) > 1 {
+		if err := image.Untag(ref); err != nil {
+			return err
+		}
+		fmt.Printf("Untagged: %s\n", image.NormalizeReference(ref))
+		return nil
+	}
+
+	containers, err := listContainerInfos()
+	if err != nil {
+		return err
+	}
+	for _, info := range containers {
+		if containerImageID(info.ImageId, info.ImageName) == id {
+			return fmt.Errorf("Image %s is being used by container %s", ref, info.Name)
+		}
+	}
+
+	for _, tag := range tags {
+		if err := image.Untag(tag); err != nil {
+			return err
+		}
+		fmt.Printf("Untagged: %s\n", tag)
+	}
+	deleted, err := image.DeleteImage(id)
+	for _, digest := range deleted {
+		fmt.Printf("Deleted: %s\n", digest)
+	}
+	return err
+}
+
+// Remove dangling images, or all unused images, then report what was
+// deleted and the space reclaimed
+func pruneImages(all bool) error {
+	inUse, legacyInUse, err := imagesInUse()
+	if err != nil {
+		return err
+	}
+	report, err := image.Prune(all, inUse)
+	if report != nil {
+		if len(report.Untagged) > 0 || len(report.Deleted) > 0 {
+			fmt.Println("Deleted Images:")
+		}
+		for _, tag := range report.Untagged {
+			fmt.Printf("untagged: %s\n", tag)
+		}
+		for _, digest := range report.Deleted {
+			fmt.Printf("deleted: %s\n", digest)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	// Unpacked rootfs of plain image tarballs are unused images too
+	var reclaimed int64
+	if all {
+		if reclaimed, err = container.PruneLegacyImages(legacyInUse); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Total reclaimed space: %s\n", humanSize(report.SpaceReclaimed+reclaimed))
+	return nil
+}
+
+// Return IDs and names of the images containers are created from
+func imagesInUse() (map[string]bool, map[string]bool, error) {
+	containers, err := listContainerInfos()
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := map[string]bool{}
+	names := map[string]bool{}
+	for _, info := range containers {
+		if id := containerImageID(info.ImageId, info.ImageName); id != "" {
+			ids[id] = true
+		}
+		names[info.ImageName] = true
+	}
+	return ids, names, nil
+}
+
+// Convert the layers of an image to squashfs for the default storage
+// driver, they are mounted instead of extracted from then on
+func convertImage(ref string) error {
+	inUse, _, err := imagesInUse()
+	if err != nil {
+		return err
+	}
+	if err := container.EnsureImage(ref); err != nil {
+		return err
+	}
+	return image.ConvertSquashfs(ref, container.DefaultStorageDriver, inUse)
+}
+
+// Containers created before the image store record only the image name
+func containerImageID(imageId, imageName string) string {
+	if imageId != "" {
+		return imageId
+	}
+	id, _ := image.Resolve(imageName)
+	return id
+}
+
+func tagImage(source, target string) error {
+	id, err := image.Resolve(source)
+	if err != nil {
+		return err
+	}
+	return image.Tag(id, target)
+}
+
+// Print the steps which created an image, the newest first
+func showHistory(ref string, asJSON, noTrunc bool) error {
+	entries, err := image.GetHistory(ref)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		content, err := json.MarshalIndent(entries, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(content))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "IMAGE\tCREATED\tCREATED BY\tSIZE\tCOMMENT\n")
+	for _, entry := range entries {
+		id := entry.Id
+		if !noTrunc {
+			id = image.ShortID(id)
+		}
+		createdBy := strings.Join(strings.Fields(entry.CreatedBy), " ")
+		if !noTrunc && len(createdBy) > 45 {
+			createdBy = createdBy[:42] + "..."
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			id,
+			formatCreated(entry.Created),
+			createdBy,
+			humanSize(entry.Size),
+			entry.Comment)
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+		return err
+	}
+	return nil
+}
+
+func inspectImage(ref string) error {
+	info, err := image.GetImageInfo(ref)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(info, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(content))
+	return nil
+}
+
+func formatCreated(created string) string {
+	t, err := time.Parse(time.RFC3339, created)
+	if err != nil {
+		return created
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
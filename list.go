@@ -7,38 +7,36 @@ import (
 	log "github.com/sirupsen/logrus"
 	"io/ioutil"
 	"os"
+	"strings"
 	"text/tabwriter"
 )
 
-func ListContainers() {
-	dirURL := fmt.Sprintf(container.DefaultInfoLocation, "")
-	dirURL = dirURL[:len(dirURL)-1]
-	files, err := ioutil.ReadDir(dirURL)
+func ListContainers(showSize bool) {
+	containers, err := listContainerInfos()
 	if err != nil {
-		log.Errorf("Read dir %s error %v", dirURL, err)
+		log.Errorf("List containers error %v", err)
 		return
 	}
 
-	var containers []*container.ContainerInfo
-	for _, file := range files {
-		tmpContainer, err := getContainerInfo(file)
-		if err != nil {
-			log.Errorf("Get container info error %v", err)
-			continue
-		}
-		containers = append(containers, tmpContainer)
-	}
-
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
+	header := "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\tMOUNTS"
+	if showSize {
+		header += "\tSIZE"
+	}
+	fmt.Fprintln(w, header)
 	for _, item := range containers {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s",
 			item.Id,
 			item.Name,
 			item.Pid,
 			item.Status,
 			item.Command,
-			item.CreatedTime)
+			item.CreatedTime,
+			formatMounts(item.AllMounts()))
+		if showSize {
+			fmt.Fprintf(w, "\t%s", formatWriteLayerSize(item))
+		}
+		fmt.Fprintln(w)
 	}
 	if err := w.Flush(); err != nil {
 		log.Errorf("Flush error %v", err)
@@ -46,6 +44,77 @@ func ListContainers() {
 	}
 }
 
+// Summarize mounts like "data:/data,tmpfs:/scratch", a volume is shown
+// by name and a bind mount by its host path
+func formatMounts(mounts []container.Mount) string {
+	var fields []string
+	for _, m := range mounts {
+		source := m.Source
+		if m.Type == container.MountTypeTmpfs {
+			source = m.Type
+		}
+		fields = append(fields, source+":"+m.Destination)
+	}
+	return strings.Join(fields, ",")
+}
+
+// Show the usage of the write layer of a container, and its limit if any
+func formatWriteLayerSize(info *container.ContainerInfo) string {
+	size := humanSize(container.WriteLayerUsage(info.Name))
+	if info.StorageSize > 0 {
+		size += " (limit " + humanSize(info.StorageSize) + ")"
+	}
+	return size
+}
+
+// Print the recorded info of containers as JSON
+func inspectContainers(names []string) error {
+	var infos []*container.ContainerInfo
+	for _, name := range names {
+		info, err := getContainerInfoByName(name)
+		if err != nil {
+			return fmt.Errorf("No Such Container: %s", name)
+		}
+		info.Mounts = info.AllMounts()
+		infos = append(infos, info)
+	}
+	content, err := json.MarshalIndent(infos, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(content))
+	return nil
+}
+
+// Read info of all containers recorded under DefaultInfoLocation
+func listContainerInfos() ([]*container.ContainerInfo, error) {
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, "")
+	dirURL = dirURL[:len(dirURL)-1]
+	files, err := ioutil.ReadDir(dirURL)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		log.Errorf("Read dir %s error %v", dirURL, err)
+		return nil, err
+	}
+
+	var containers []*container.ContainerInfo
+	for _, file := range files {
+		// Network configurations are kept under the same directory
+		if file.Name() == "network" {
+			continue
+		}
+		tmpContainer, err := getContainerInfo(file)
+		if err != nil {
+			log.Errorf("Get container info error %v", err)
+			continue
+		}
+		containers = append(containers, tmpContainer)
+	}
+	return containers, nil
+}
+
 func getContainerInfo(file os.FileInfo) (*container.ContainerInfo, error) {
 	containerName := file.Name()
 	configFileDir := fmt.Sprintf(container.DefaultInfoLocation, containerName)
@@ -22,14 +22,32 @@ func main() {
 		initCommand,
 		runCommand,
 		commitCommand,
+		buildCommand,
+		diffCommand,
+		exportCommand,
+		copyCommand,
+		importCommand,
 		listCommand,
+		inspectCommand,
 		logCommand,
 		execCommand,
 		stopCommand,
 		removeCommand,
 		networkCommand,
+		volumeCommand,
+		imageCommand,
+		imagesCommand,
+		historyCommand,
+		removeImageCommand,
+		tagCommand,
+		saveCommand,
+		loadCommand,
+		pullCommand,
+		pushCommand,
 	}
 
+	app.Flags = configFlags
+
 	app.Before = func(context *cli.Context) error {
 
 		// Setup logger
@@ -43,7 +61,8 @@ func main() {
 		log.SetFormatter(textFormatter)
 		log.SetOutput(os.Stdout)
 
-		return nil
+		// Paths and defaults of config file, environment and flags
+		return setUpConfig(context)
 	}
 
 	if err := app.Run(os.Args); err != nil {
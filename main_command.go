@@ -1,13 +1,17 @@
 package main
 
 import (
+	"./build"
 	"./cgroups/subsystems"
 	"./container"
+	"./image"
 	"./network"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 // To start a container:
@@ -21,7 +25,12 @@ var runCommand = cli.Command{
 		mydocker run [image] -d --name [container name] [command]
 		mydocker run [image] --cpushare [250] --cpuset [1] -m [128m] [command]
 		mydocker run [image] -v [parent_url:container_url] [command]
+		mydocker run [image] -v [volume name:container_url] [command]
 		mydocker run [image] -e [myenv:value] -ti [command]
+		mydocker run [image] --storage-driver [overlay/aufs] [command]
+		mydocker run --entrypoint [executable] -w [dir] -u [user[:group]] [image] [args]
+		mydocker run --rootfs [dir] [command]
+	The command defaults to the Entrypoint and Cmd of the image.
 	Example:
 		mydocker run busybox --name demo -d --cpuset 1 -m 128m -e my_var=122 "sleep 2"`,
 	Flags: []cli.Flag{
@@ -41,9 +50,17 @@ var runCommand = cli.Command{
 			Name:  "cpuset",
 			Usage: "cpuset limit",
 		},
-		cli.StringFlag{
+		cli.StringSliceFlag{
 			Name:  "v",
-			Usage: "volume",
+			Usage: "bind mount a host path or volume: -v src:dst[:ro], repeatable",
+		},
+		cli.StringSliceFlag{
+			Name:  "mount",
+			Usage: "attach a mount: --mount type=bind|volume|tmpfs,src=...,dst=...[,readonly][,bind-propagation=...]",
+		},
+		cli.StringSliceFlag{
+			Name:  "tmpfs",
+			Usage: "mount a tmpfs: --tmpfs /path[:size=64m,mode=1777,noexec], repeatable",
 		},
 		cli.BoolFlag{
 			Name:  "d",
@@ -59,39 +76,114 @@ var runCommand = cli.Command{
 		},
 		cli.StringFlag{
 			Name:  "net",
-			Usage: "container network",
+			Usage: "container network, none to disable the default network",
 		},
 		cli.StringSliceFlag{
 			Name:  "p",
 			Usage: "port mapping",
 		},
+		cli.StringFlag{
+			Name:  "storage-driver",
+			Usage: "storage driver of container rootfs (overlay, aufs)",
+		},
+		cli.BoolFlag{
+			Name:  "read-only",
+			Usage: "mount the rootfs read-only, only mounts are writable",
+		},
+		cli.StringSliceFlag{
+			Name:  "storage-opt",
+			Usage: "storage driver options, size=2G limits the write layer",
+		},
+		cli.StringFlag{
+			Name:  "entrypoint",
+			Usage: "overwrite the entrypoint of the image",
+		},
+		cli.StringFlag{
+			Name:  "w",
+			Usage: "working directory inside the container",
+		},
+		cli.StringFlag{
+			Name:  "u",
+			Usage: "user[:group] running the command",
+		},
+		cli.StringSliceFlag{
+			Name:  "l",
+			Usage: "set label key=value",
+		},
+		cli.StringFlag{
+			Name:  "stop-signal",
+			Usage: "signal sent by stop, default is the image's or SIGTERM",
+		},
+		cli.BoolFlag{
+			Name:  "insecure-skip-verify",
+			Usage: "run the image even if the signature policy rejects it",
+		},
+		cli.StringFlag{
+			Name:  "rootfs",
+			Usage: "run from a rootfs directory or OCI bundle instead of an image",
+		},
 	},
 
 	// 1. check if parameters include `command`
 	// 2. get user specified command
 	// 3. call `run` function to prepare for container setup
 	Action: func(context *cli.Context) error {
-		// Assert that command must have at least one arg
-		if len(context.Args()) < 1 {
-			return fmt.Errorf("Missing container command")
+		// Assert that command must have at least the image, or the
+		// command when running from a rootfs directory
+		rootfs := context.String("rootfs")
+		if len(context.Args()) < 1 && rootfs == "" {
+			return fmt.Errorf("Missing image name")
 		}
 
-		// Setup user specified container configuration
-		var cmdArray []string
-		for _, arg := range context.Args() {
-			cmdArray = append(cmdArray, arg)
+		// Setup user specified container configuration. Arguments are
+		// passed as they are, only a single quoted command like "sleep 2"
+		// is split into its words.
+		imageName, args := "", []string(context.Args())
+		if rootfs == "" {
+			imageName, args = args[0], args[1:]
+		} else {
+			dir, err := container.RootfsDir(rootfs)
+			if err != nil {
+				return err
+			}
+			rootfs = dir
+		}
+		cmdArray := args
+		if len(args) == 1 && strings.ContainsAny(args[0], " \t") {
+			cmdArray = strings.Fields(args[0])
+		}
+		mounts, err := parseMounts(context.StringSlice("v"), context.StringSlice("mount"),
+			context.StringSlice("tmpfs"))
+		if err != nil {
+			return err
+		}
+		storageSize, err := container.ParseStorageOpts(context.StringSlice("storage-opt"))
+		if err != nil {
+			return err
+		}
+		if storageSize > 0 && context.Bool("read-only") {
+			return fmt.Errorf("A read-only container has no write layer to limit with --storage-opt")
 		}
 		config := &container.ContainerConfig{
-			TTY:         context.Bool("ti") || !context.Bool("d"),
-			Env:         context.StringSlice("e"),
-			Name:        context.String("name"),
-			ID:          randStringBytes(10),
-			Volume:      context.String("v"),
-			Pipe:        nil,
-			ImageName:   cmdArray[0],
-			CmdArray:    cmdArray[1:],
-			NetworkName: context.String("net"),
-			PortMapping: context.StringSlice("p"),
+			TTY:           context.Bool("ti") || !context.Bool("d"),
+			Env:           context.StringSlice("e"),
+			Name:          context.String("name"),
+			ID:            randStringBytes(10),
+			Mounts:        mounts,
+			Pipe:          nil,
+			ImageName:     imageName,
+			Rootfs:        rootfs,
+			ReadOnly:      context.Bool("read-only"),
+			CmdArray:      cmdArray,
+			NetworkName:   networkOrDefault(context.String("net")),
+			PortMapping:   context.StringSlice("p"),
+			StorageDriver: context.String("storage-driver"),
+			StorageSize:   storageSize,
+			WorkingDir:    context.String("w"),
+			User:          context.String("u"),
+			Labels:        parseLabels(context.StringSlice("l")),
+			StopSignal:    context.String("stop-signal"),
+			SkipVerify:    context.Bool("insecure-skip-verify"),
 			Resource: &subsystems.ResourceConfig{
 				MemoryLimit: context.String("m"),
 				CpuSet:      context.String("cpuset"),
@@ -102,6 +194,14 @@ var runCommand = cli.Command{
 		if config.Name == "" {
 			config.Name = config.ID
 		}
+		// Record the driver explicitly, so that `rm` tears down the
+		// workspace with the same driver even if the default changes
+		if config.StorageDriver == "" {
+			config.StorageDriver = container.DefaultStorageDriver
+		}
+		if err := mergeImageConfig(config, context.String("entrypoint")); err != nil {
+			return err
+		}
 
 		// Refer to file: run.go
 		// Wait here until `cmd` exit
@@ -117,7 +217,7 @@ var runCommand = cli.Command{
 
 // This command is invoked by child process
 var initCommand = cli.Command{
-	Name: "init",
+	Name:  "init",
 	Usage: `[Do not call it] Init container process run user's process in container.`,
 
 	// 1. get passed command parameters (use pipe instead)
@@ -134,29 +234,142 @@ var initCommand = cli.Command{
 
 var commitCommand = cli.Command{
 	Name: "commit",
-	Usage: `commit a container into image
-		mydocker commit [image name]`,
+	Usage: `commit the changes of a container into a new image
+		mydocker commit [container name] [image name:tag]
+		mydocker commit --author [author] -m [message] --pause [container name] [image name:tag]`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "author",
+			Usage: "author of the image",
+		},
+		cli.StringFlag{
+			Name:  "m",
+			Usage: "commit message",
+		},
+		cli.BoolFlag{
+			Name:  "pause",
+			Usage: "pause the container during commit",
+		},
+	},
 	Action: func(context *cli.Context) error {
 
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("Missing container name or image name")
+		}
+		containerName := context.Args().Get(0)
+		imageName := context.Args().Get(1)
+		options := &image.CommitOptions{
+			Author:  context.String("author"),
+			Comment: context.String("m"),
+		}
+		return commitContainer(containerName, imageName, options, context.Bool("pause"))
+	},
+}
+
+var diffCommand = cli.Command{
+	Name: "diff",
+	Usage: `show files added (A), changed (C) and deleted (D) in a container
+		mydocker diff [container name]
+		mydocker diff --json [container name]`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "print changes as JSON",
+		},
+	},
+	Action: func(context *cli.Context) error {
 		if len(context.Args()) < 1 {
 			return fmt.Errorf("Missing container name")
 		}
-		imageName := context.Args().Get(0)
-		commitContainer(imageName)
-		return nil
+		return diffContainer(context.Args().Get(0), context.Bool("json"))
+	},
+}
+
+var exportCommand = cli.Command{
+	Name: "export",
+	Usage: `export the filesystem of a container as a tarball
+		mydocker export -o [tarball] [container name]`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "o",
+			Usage: "output tarball, default is STDOUT",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		return exportContainer(context.Args().Get(0), context.String("o"))
+	},
+}
+
+var importCommand = cli.Command{
+	Name: "import",
+	Usage: `create a single-layer image from a rootfs tarball or directory
+		mydocker import [tarball/dir/-] [image name:tag]
+		mydocker import --cmd [command] -e [myenv=value] [tarball/dir/-] [image name:tag]
+	Example:
+		mydocker import --cmd "/bin/sh" -e PATH=/bin rootfs.tar mybox:1.0`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "cmd",
+			Usage: "default command of the image",
+		},
+		cli.StringSliceFlag{
+			Name:  "e",
+			Usage: "set environment",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("Missing source or image name")
+		}
+		return importImage(context.Args().Get(0), context.Args().Get(1),
+			context.String("cmd"), context.StringSlice("e"))
 	},
 }
 
 var listCommand = cli.Command{
 	Name: "ps",
 	Usage: `list all the containers
-		mydocker ps`,
+		mydocker ps [-s]`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "s, size",
+			Usage: "show the size of the write layer of containers",
+		},
+	},
 	Action: func(context *cli.Context) error {
-		ListContainers()
+		ListContainers(context.Bool("size"))
 		return nil
 	},
 }
 
+var copyCommand = cli.Command{
+	Name: "cp",
+	Usage: `copy files between a container and the host, - is a tarball on stdin or stdout
+		mydocker cp [container]:[path] [host path|-]
+		mydocker cp [host path|-] [container]:[path]`,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("Missing source or destination")
+		}
+		return copyFiles(context.Args().Get(0), context.Args().Get(1))
+	},
+}
+
+var inspectCommand = cli.Command{
+	Name: "inspect",
+	Usage: `show details of containers, including their mounts
+		mydocker inspect [container name...]`,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		return inspectContainers(context.Args())
+	},
+}
+
 var logCommand = cli.Command{
 	Name: "logs",
 	Usage: `print logs of a container
@@ -225,6 +438,74 @@ var removeCommand = cli.Command{
 	},
 }
 
+var volumeCommand = cli.Command{
+	Name: "volume",
+	Usage: `named volume commands
+		mydocker volume create --driver [driver] --label [key=value] [volume name]
+		mydocker volume ls
+		mydocker volume inspect [volume name...]
+		mydocker volume rm [volume name...]
+		mydocker volume prune
+	Example:
+		mydocker volume create data
+		mydocker run -v data:/data busybox sh`,
+	Subcommands: []cli.Command{
+		{
+			Name:  "create",
+			Usage: "create a volume, named randomly without name",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "driver",
+					Usage: "volume driver (local)",
+				},
+				cli.StringSliceFlag{
+					Name:  "label",
+					Usage: "set label key=value",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				return createVolume(context.Args().Get(0), context.String("driver"), context.StringSlice("label"))
+			},
+		},
+		{
+			Name:    "ls",
+			Aliases: []string{"list"},
+			Usage:   "list volumes",
+			Action: func(context *cli.Context) error {
+				return listVolumes()
+			},
+		},
+		{
+			Name:  "inspect",
+			Usage: "print volume metadata as JSON",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing volume name")
+				}
+				return inspectVolumes(context.Args())
+			},
+		},
+		{
+			Name:    "rm",
+			Aliases: []string{"remove"},
+			Usage:   "remove volumes not used by any container",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing volume name")
+				}
+				return removeVolumes(context.Args())
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "remove volumes not used by any container",
+			Action: func(context *cli.Context) error {
+				return pruneVolumes()
+			},
+		},
+	},
+}
+
 var networkCommand = cli.Command{
 	Name: "network",
 	Usage: `container network commands
@@ -286,3 +567,392 @@ var networkCommand = cli.Command{
 		},
 	},
 }
+
+var imagesCommand = cli.Command{
+	Name: "images",
+	Usage: `list images
+		mydocker images [-a]`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "a",
+			Usage: "show intermediate images of builds",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return listImages(context.Bool("a"))
+	},
+}
+
+var historyCommand = cli.Command{
+	Name: "history",
+	Usage: `show the steps which created an image and the size of their layers
+		mydocker history [image name/id]
+		mydocker history --json [image name/id]`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "print history as JSON",
+		},
+		cli.BoolFlag{
+			Name:  "no-trunc",
+			Usage: "do not truncate IDs and commands",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		return showHistory(context.Args().Get(0), context.Bool("json"), context.Bool("no-trunc"))
+	},
+}
+
+var removeImageCommand = cli.Command{
+	Name: "rmi",
+	Usage: `remove images not used by any container
+		mydocker rmi [image name/id...]`,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		for _, ref := range context.Args() {
+			if err := removeImage(ref); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+var tagCommand = cli.Command{
+	Name: "tag",
+	Usage: `create a reference to an image
+		mydocker tag [source image] [target name:tag]`,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("Missing source or target image name")
+		}
+		return tagImage(context.Args().Get(0), context.Args().Get(1))
+	},
+}
+
+var saveCommand = cli.Command{
+	Name: "save",
+	Usage: `save images into a tarball compatible with "docker load"
+		mydocker save -o [tarball] [image name...]`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "o",
+			Usage: "output tarball, default is STDOUT",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		output := os.Stdout
+		if context.String("o") != "" {
+			file, err := os.Create(context.String("o"))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			output = file
+		} else {
+			// Keep logs out of the archive stream
+			log.SetOutput(os.Stderr)
+		}
+		return image.SaveDockerArchive(context.Args(), output)
+	},
+}
+
+var loadCommand = cli.Command{
+	Name: "load",
+	Usage: `load images from a tarball created by "docker save"
+		mydocker load -i [tarball]`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "i",
+			Usage: "input tarball, default is STDIN",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		input := os.Stdin
+		if context.String("i") != "" {
+			file, err := os.Open(context.String("i"))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			input = file
+		}
+		loaded, err := image.LoadDockerArchive(input)
+		if err != nil {
+			return err
+		}
+		for _, ref := range loaded {
+			fmt.Printf("Loaded image: %s\n", ref)
+		}
+		return nil
+	},
+}
+
+// Credentials and transport of an image registry
+var registryFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "u",
+		Usage:  "registry username",
+		EnvVar: "MYDOCKER_REGISTRY_USER",
+	},
+	cli.StringFlag{
+		Name:   "p",
+		Usage:  "registry password",
+		EnvVar: "MYDOCKER_REGISTRY_PASSWORD",
+	},
+	cli.BoolFlag{
+		Name:  "insecure",
+		Usage: "use plain HTTP, always done for localhost",
+	},
+}
+
+func registryAuth(context *cli.Context) *image.RegistryAuth {
+	return &image.RegistryAuth{
+		Username: context.String("u"),
+		Password: context.String("p"),
+		Insecure: context.Bool("insecure"),
+	}
+}
+
+var pullCommand = cli.Command{
+	Name: "pull",
+	Usage: `pull an image from a registry
+		mydocker pull [-u user -p password] [registry/]name[:tag|@digest]
+	Example:
+		mydocker pull localhost:5000/busybox:1.29`,
+	Flags: registryFlags,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		id, err := image.Pull(context.Args().Get(0), registryAuth(context))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Digest: %s\n", id)
+		return nil
+	},
+}
+
+var pushCommand = cli.Command{
+	Name: "push",
+	Usage: `push an image to the registry named by its tag
+		mydocker push [-u user -p password] [registry/]name[:tag]
+	Example:
+		mydocker tag busybox localhost:5000/busybox:1.29
+		mydocker push localhost:5000/busybox:1.29`,
+	Flags: registryFlags,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		return image.Push(context.Args().Get(0), registryAuth(context))
+	},
+}
+
+var buildCommand = cli.Command{
+	Name: "build",
+	Usage: `build an image from a Buildfile
+		mydocker build -f [Buildfile] -t [image name:tag] [context dir]
+	Example:
+		mydocker build -t hello:1.0 --net testbridge .`,
+	Flags: append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "f",
+			Usage: "path of the Buildfile, default is <context dir>/Buildfile",
+		},
+		cli.StringFlag{
+			Name:  "t",
+			Usage: "name and tag of the image",
+		},
+		cli.BoolFlag{
+			Name:  "no-cache",
+			Usage: "do not use cached steps",
+		},
+		cli.StringFlag{
+			Name:  "net",
+			Usage: "network of RUN containers",
+		},
+		cli.StringFlag{
+			Name:  "m",
+			Usage: "memory limit of RUN containers",
+		},
+		cli.StringFlag{
+			Name:  "cpushare",
+			Usage: "cpushare limit of RUN containers",
+		},
+		cli.StringFlag{
+			Name:  "cpuset",
+			Usage: "cpuset limit of RUN containers",
+		},
+		cli.BoolFlag{
+			Name:  "insecure-skip-verify",
+			Usage: "build even if the signature policy rejects the base image",
+		},
+	}, registryFlags...),
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing context dir")
+		}
+		contextDir := context.Args().Get(0)
+		buildfile := context.String("f")
+		if buildfile == "" {
+			buildfile = filepath.Join(contextDir, "Buildfile")
+		}
+		options := &build.Options{
+			Buildfile:  buildfile,
+			ContextDir: contextDir,
+			Tag:        context.String("t"),
+			NoCache:    context.Bool("no-cache"),
+			SkipVerify: context.Bool("insecure-skip-verify"),
+			Auth:       registryAuth(context),
+		}
+		resource := &subsystems.ResourceConfig{
+			MemoryLimit: context.String("m"),
+			CpuSet:      context.String("cpuset"),
+			CpuShare:    context.String("cpushare"),
+		}
+		return buildImage(options, resource, networkOrDefault(context.String("net")))
+	},
+}
+
+var imageCommand = cli.Command{
+	Name: "image",
+	Usage: `image commands
+		mydocker image import --oci [layout dir/tar] [image name]
+		mydocker image export --oci -o [layout dir/tar] [image name]
+		mydocker image inspect [image name/id]
+		mydocker image prune [-a]
+		mydocker image sign --key [private key] [image name/id]
+		mydocker image convert --squashfs [image name/id]
+	Example:
+		mydocker image export --oci -o busybox-oci.tar busybox`,
+	Subcommands: []cli.Command{
+		{
+			Name:  "import",
+			Usage: "import images from an OCI image layout",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "oci",
+					Usage: "source is an OCI image layout",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing image layout path")
+				}
+				if !context.Bool("oci") {
+					return fmt.Errorf("Only --oci image layouts can be imported")
+				}
+				ids, err := image.ImportOCI(context.Args().Get(0), context.Args().Get(1))
+				if err != nil {
+					return err
+				}
+				for _, id := range ids {
+					fmt.Println(id)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "export",
+			Usage: "export an image as an OCI image layout",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "oci",
+					Usage: "write an OCI image layout",
+				},
+				cli.StringFlag{
+					Name:  "o",
+					Usage: "output layout dir, or tarball if ending with .tar",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing image name")
+				}
+				if !context.Bool("oci") {
+					return fmt.Errorf("Only --oci image layouts can be exported")
+				}
+				if context.String("o") == "" {
+					return fmt.Errorf("Missing output path")
+				}
+				return image.ExportOCI(context.Args().Get(0), context.String("o"))
+			},
+		},
+		{
+			Name:  "inspect",
+			Usage: "print image metadata as JSON",
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing image name")
+				}
+				return inspectImage(context.Args().Get(0))
+			},
+		},
+		{
+			Name:  "prune",
+			Usage: "remove images without tags and the layers no image uses",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "all, a",
+					Usage: "remove all images not used by a container",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				return pruneImages(context.Bool("all"))
+			},
+		},
+		{
+			Name:  "sign",
+			Usage: "sign the manifest digest of an image with an ed25519 or ECDSA key",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "key",
+					Usage: "PEM encoded private key",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing image name")
+				}
+				if context.String("key") == "" {
+					return fmt.Errorf("Missing private key")
+				}
+				sigPath, err := image.SignImage(context.Args().Get(0), context.String("key"))
+				if err != nil {
+					return err
+				}
+				fmt.Println(sigPath)
+				return nil
+			},
+		},
+		{
+			Name:  "convert",
+			Usage: "convert image layers to squashfs, mounted instead of extracted",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "squashfs",
+					Usage: "convert layers to squashfs",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing image name")
+				}
+				if !context.Bool("squashfs") {
+					return fmt.Errorf("Only --squashfs conversion is supported")
+				}
+				return convertImage(context.Args().Get(0))
+			},
+		},
+	},
+}
@@ -16,6 +16,18 @@ type IPAM struct {
 	Subnets             *map[string]string
 }
 
+// Keep networks and IP allocations under stateDir instead of
+// /var/run/mydocker/network
+func SetStateDir(stateDir string) {
+	defaultNetworkPath = path.Join(stateDir, "network") + "/"
+	ipAllocator.SubnetAllocatorPath = path.Join(stateDir, "ipam", "subnet.json")
+}
+
+// Return the directory networks and IP allocations are kept in
+func StateDir() string {
+	return path.Dir(path.Clean(defaultNetworkPath))
+}
+
 // An IPAM singleton instance
 var ipAllocator = &IPAM{
 	SubnetAllocatorPath: ipamDefaultAllocatorPath,
@@ -3,6 +3,7 @@ package main
 import (
 	"./cgroups"
 	"./container"
+	"./image"
 	"./network"
 	"encoding/json"
 	"fmt"
@@ -20,8 +21,7 @@ func Run(config *container.ContainerConfig) {
 	// Commands that going to be executed by the new child process
 	// is now passed through a pipe.
 	log.Infof("Prepare container process ...")
-	containerProcess, writePipe := container.NewParentProcess(
-		config.TTY, config.Name, config.Volume, config.ImageName, config.Env)
+	containerProcess, writePipe := container.NewParentProcess(config)
 	if containerProcess == nil {
 		log.Errorf("New containerProcess process error")
 		return
@@ -67,7 +67,7 @@ func Run(config *container.ContainerConfig) {
 
 	// Pass commands to container process via os.Pipe
 	// "stress --vm-bytes 200m --vm-keep -m 1" -> pipe -> container
-	sendInitCommand(config.CmdArray, writePipe)
+	sendInitCommand(config, writePipe)
 
 	// Waite for container process exit or isolate container if detach mode specified
 	if config.TTY {
@@ -78,7 +78,7 @@ func Run(config *container.ContainerConfig) {
 
 		// Tear down
 		deleteContainerInfo(config.Name)
-		container.DeleteWorkSpace(config.Volume, config.Name)
+		container.DeleteWorkSpace(config.Mounts, config.Name, config.StorageDriver)
 		syscall.Mount("proc", "/proc", "proc",
 			uintptr(syscall.MS_NOEXEC|syscall.MS_NOSUID|syscall.MS_NODEV), "")
 		log.Infof("$ mount proc proc /proc")
@@ -91,16 +91,89 @@ func Run(config *container.ContainerConfig) {
 	}
 }
 
+// Fill config with the defaults of its image the way Docker does. Flags
+// win over the image config and environment variables are merged. The
+// command runs the entrypoint with the arguments given on the command
+// line, or with the image Cmd if there are none. A new entrypoint drops
+// the image Cmd, which was meant for the old one.
+func mergeImageConfig(config *container.ContainerConfig, entrypoint string) error {
+	if config.Rootfs != "" {
+		// A rootfs directory has no config, the command must be given
+		if entrypoint != "" {
+			config.CmdArray = append([]string{entrypoint}, config.CmdArray...)
+		}
+		if len(config.CmdArray) == 0 {
+			return fmt.Errorf("No command specified for rootfs %s", config.Rootfs)
+		}
+		return nil
+	}
+	if err := container.EnsureImage(config.ImageName); err != nil {
+		return err
+	}
+	_, _, img, err := image.GetImage(config.ImageName)
+	if err != nil {
+		return err
+	}
+	imageConfig := img.Config
+
+	cmd := config.CmdArray
+	command := imageConfig.Entrypoint
+	if entrypoint != "" {
+		command = []string{entrypoint}
+	} else if len(cmd) == 0 {
+		cmd = imageConfig.Cmd
+	}
+	config.CmdArray = append(append([]string{}, command...), cmd...)
+	if len(config.CmdArray) == 0 {
+		return fmt.Errorf("No command specified, image %s has no Cmd or Entrypoint", config.ImageName)
+	}
+
+	// Later values of a variable override earlier ones
+	config.Env = append(append([]string{}, imageConfig.Env...), config.Env...)
+	if config.WorkingDir == "" {
+		config.WorkingDir = imageConfig.WorkingDir
+	}
+	if config.User == "" {
+		config.User = imageConfig.User
+	}
+	if config.StopSignal == "" {
+		config.StopSignal = imageConfig.StopSignal
+	}
+	labels := map[string]string{}
+	for key, value := range imageConfig.Labels {
+		labels[key] = value
+	}
+	for key, value := range config.Labels {
+		labels[key] = value
+	}
+	config.Labels = labels
+	return nil
+}
+
 func makeContainerInfo(pid int, config *container.ContainerConfig) *container.ContainerInfo {
 	containerInfo := &container.ContainerInfo{
-		Id:          config.ID,
-		Name:        config.Name,
-		Volume:      config.Volume,
-		Pid:         strconv.Itoa(pid),
-		Command:     strings.Join(config.CmdArray, ""),
-		CreatedTime: time.Now().Format("2006-01-02 15:04:05"),
-		Status:      container.RUNNING,
-		PortMapping: config.PortMapping,
+		Id:            config.ID,
+		Name:          config.Name,
+		Mounts:        config.Mounts,
+		Pid:           strconv.Itoa(pid),
+		Command:       strings.Join(config.CmdArray, " "),
+		CreatedTime:   time.Now().Format("2006-01-02 15:04:05"),
+		Status:        container.RUNNING,
+		PortMapping:   config.PortMapping,
+		StorageDriver: config.StorageDriver,
+		StorageSize:   config.StorageSize,
+		StorageQuota:  config.StorageQuota,
+		ImageName:     config.ImageName,
+		Rootfs:        config.Rootfs,
+		ReadOnly:      config.ReadOnly,
+		Labels:        config.Labels,
+		StopSignal:    config.StopSignal,
+	}
+	if config.ImageName == "" {
+		return containerInfo
+	}
+	if id, err := image.Resolve(config.ImageName); err == nil {
+		containerInfo.ImageId = id
 	}
 
 	return containerInfo
@@ -145,9 +218,19 @@ func deleteContainerInfo(containerId string) {
 	}
 }
 
-func sendInitCommand(comArray []string, writePipe *os.File) {
-	command := strings.Join(comArray, " ")
-	log.Infof("Send: [%s] -> pipe", command)
-	writePipe.WriteString(command)
+func sendInitCommand(config *container.ContainerConfig, writePipe *os.File) {
+	initConfig := &container.InitConfig{
+		Cmd:        config.CmdArray,
+		WorkingDir: config.WorkingDir,
+		User:       config.User,
+		Mounts:     config.Mounts,
+		ReadOnly:   config.ReadOnly,
+	}
+	content, err := json.Marshal(initConfig)
+	if err != nil {
+		log.Errorf("Marshal init config error %v", err)
+	}
+	log.Infof("Send: %v -> pipe", config.CmdArray)
+	writePipe.Write(content)
 	writePipe.Close()
 }
@@ -26,18 +26,25 @@ func stopContainer(containerName string) {
 		log.Errorf("Conver pid from string to int error %v", err)
 		return
 	}
-	if err := syscall.Kill(pidInt, syscall.SIGTERM); err != nil {
-		log.Errorf("Stop container %s error %v", containerName, err)
-		return
-	} else {
-		log.Infof("$ kill --signal TERM %d", pidInt)
-	}
-
 	containerInfo, err := getContainerInfoByName(containerName)
 	if err != nil {
 		log.Errorf("Get container %s info error %v", containerName, err)
 		return
 	}
+	signal := syscall.SIGTERM
+	if containerInfo.StopSignal != "" {
+		if signal, err = parseSignal(containerInfo.StopSignal); err != nil {
+			log.Errorf("Stop container %s error %v", containerName, err)
+			return
+		}
+	}
+	if err := syscall.Kill(pidInt, signal); err != nil {
+		log.Errorf("Stop container %s error %v", containerName, err)
+		return
+	} else {
+		log.Infof("$ kill --signal %d %d", signal, pidInt)
+	}
+
 	containerInfo.Status = container.STOP
 	containerInfo.Pid = " "
 	newContentBytes, err := json.Marshal(containerInfo)
@@ -83,5 +90,5 @@ func removeContainer(containerName string) {
 		log.Errorf("Remove file %s error %v", dirURL, err)
 		return
 	}
-	container.DeleteWorkSpace(containerInfo.Volume, containerName)
+	container.DeleteWorkSpace(containerInfo.AllMounts(), containerName, containerInfo.StorageDriver)
 }
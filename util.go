@@ -1,7 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"math/rand"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -14,3 +18,54 @@ func randStringBytes(n int) string {
 	}
 	return string(b)
 }
+
+// Format bytes as a human readable size, for example 1.5MB
+func humanSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", size)
+	}
+	return fmt.Sprintf("%.3g%s", value, units[i])
+}
+
+// Parse "key=value" labels, a label without value is empty
+func parseLabels(labelSlice []string) map[string]string {
+	labels := map[string]string{}
+	for _, label := range labelSlice {
+		kv := strings.SplitN(label, "=", 2)
+		labels[kv[0]] = ""
+		if len(kv) == 2 {
+			labels[kv[0]] = kv[1]
+		}
+	}
+	return labels
+}
+
+var signals = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"KILL":  syscall.SIGKILL,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"TERM":  syscall.SIGTERM,
+	"STOP":  syscall.SIGSTOP,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// Parse a signal given as a name like SIGTERM or TERM, or as a number
+func parseSignal(name string) (syscall.Signal, error) {
+	if number, err := strconv.Atoi(name); err == nil && number > 0 && number < 65 {
+		return syscall.Signal(number), nil
+	}
+	if signal, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]; ok {
+		return signal, nil
+	}
+	return 0, fmt.Errorf("Invalid signal: %s", name)
+}
@@ -0,0 +1,199 @@
+package volume
+
+import (
+	"../archive"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Named volumes are directories managed by mydocker, they outlive the
+// containers using them and can be shared between containers:
+//
+// /root/volumes/<name>/_data         mounted into containers
+// /root/volumes/<name>/volume.json   metadata of the volume
+var (
+	VolumeUrl = "/root/volumes"
+)
+
+const (
+	DefaultDriver = "local"
+	dataDir       = "_data"
+	metadataFile  = "volume.json"
+)
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+type Volume struct {
+	Name       string            `json:"name"`
+	Driver     string            `json:"driver"`
+	Mountpoint string            `json:"mountpoint"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	CreatedAt  string            `json:"createdAt"`
+	UsedBy     []string          `json:"usedBy,omitempty"` // Containers using the volume, not persisted
+}
+
+// Return whether the source of `-v source:dest` is a volume name rather
+// than a host path
+func IsNamed(source string) bool {
+	return source != "" && !strings.HasPrefix(source, "/") && !strings.HasPrefix(source, ".")
+}
+
+// Create a volume, an empty name gets a random one. Creating an existing
+// volume returns it unchanged.
+func Create(name, driver string, labels map[string]string) (*Volume, error) {
+	if driver == "" {
+		driver = DefaultDriver
+	}
+	if driver != DefaultDriver {
+		return nil, fmt.Errorf("No Such Volume Driver: %s", driver)
+	}
+	if name == "" {
+		name = randomName()
+	}
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("Invalid volume name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+
+	if err := os.MkdirAll(VolumeUrl, 0700); err != nil {
+		return nil, err
+	}
+	// Mkdir fails if the volume exists, even when created concurrently
+	volumeDir := path.Join(VolumeUrl, name)
+	if err := os.Mkdir(volumeDir, 0755); err != nil {
+		if os.IsExist(err) {
+			return Get(name)
+		}
+		return nil, err
+	}
+	volume := &Volume{
+		Name:       name,
+		Driver:     driver,
+		Mountpoint: path.Join(volumeDir, dataDir),
+		Labels:     labels,
+		CreatedAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := os.Mkdir(volume.Mountpoint, 0755); err != nil {
+		os.RemoveAll(volumeDir)
+		return nil, err
+	}
+	if err := volume.dump(); err != nil {
+		os.RemoveAll(volumeDir)
+		return nil, err
+	}
+	log.Infof("$ mkdir -p %s", volume.Mountpoint)
+	return volume, nil
+}
+
+func (volume *Volume) dump() error {
+	content, err := json.Marshal(volume)
+	if err != nil {
+		return err
+	}
+	metadataPath := path.Join(VolumeUrl, volume.Name, metadataFile)
+	tmpPath := metadataPath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, metadataPath)
+}
+
+func Get(name string) (*Volume, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("No Such Volume: %s", name)
+	}
+	content, err := ioutil.ReadFile(path.Join(VolumeUrl, name, metadataFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("No Such Volume: %s", name)
+		}
+		return nil, err
+	}
+	var volume Volume
+	if err := json.Unmarshal(content, &volume); err != nil {
+		return nil, fmt.Errorf("Read volume %s error: %v", name, err)
+	}
+	return &volume, nil
+}
+
+// Return all volumes sorted by name
+func List() ([]*Volume, error) {
+	files, err := ioutil.ReadDir(VolumeUrl)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var volumes []*Volume
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		volume, err := Get(file.Name())
+		if err != nil {
+			log.Warnf("Load volume %s error %v", file.Name(), err)
+			continue
+		}
+		volumes = append(volumes, volume)
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Name < volumes[j].Name
+	})
+	return volumes, nil
+}
+
+// Remove a volume and its data. Callers check that no container uses it.
+func Remove(name string) error {
+	if _, err := Get(name); err != nil {
+		return err
+	}
+	volumeDir := path.Join(VolumeUrl, name)
+	// Without metadata a partly removed volume is not listed anymore
+	if err := os.Remove(path.Join(volumeDir, metadataFile)); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(volumeDir); err != nil {
+		return err
+	}
+	log.Infof("$ rm -rf %s", volumeDir)
+	return nil
+}
+
+// Remove volumes not in inUse, and return their names and the bytes freed
+func Prune(inUse map[string]bool) ([]string, int64, error) {
+	volumes, err := List()
+	if err != nil {
+		return nil, 0, err
+	}
+	var removed []string
+	var reclaimed int64
+	for _, volume := range volumes {
+		if inUse[volume.Name] {
+			continue
+		}
+		size := archive.DiskUsage(volume.Mountpoint)
+		if err := Remove(volume.Name); err != nil {
+			return removed, reclaimed, err
+		}
+		removed = append(removed, volume.Name)
+		reclaimed += size
+	}
+	return removed, reclaimed, nil
+}
+
+// Anonymous volumes are named by 32 random bytes, like image IDs
+func randomName() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,71 @@
+package volume
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVolumes(t *testing.T) {
+	root, err := ioutil.TempDir("", "mydocker-volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	VolumeUrl = root
+
+	data, err := Create("data", "", map[string]string{"app": "db"})
+	if err != nil {
+		t.Fatalf("create volume %v", err)
+	}
+	ioutil.WriteFile(filepath.Join(data.Mountpoint, "table"), []byte("rows"), 0644)
+	if again, err := Create("data", "", nil); err != nil || again.Labels["app"] != "db" {
+		t.Errorf("create existing volume = %+v, %v", again, err)
+	}
+	anonymous, err := Create("", "", nil)
+	if err != nil || len(anonymous.Name) != 64 {
+		t.Fatalf("create anonymous volume = %+v, %v", anonymous, err)
+	}
+	for _, name := range []string{"../escape", "a", "/abs"} {
+		if _, err := Create(name, "", nil); err == nil {
+			t.Errorf("invalid name %q accepted", name)
+		}
+	}
+	if _, err := Create("other", "nfs", nil); err == nil {
+		t.Errorf("unknown driver accepted")
+	}
+
+	volumes, err := List()
+	if err != nil || len(volumes) != 2 {
+		t.Fatalf("list = %v, %v", volumes, err)
+	}
+
+	removed, reclaimed, err := Prune(map[string]bool{"data": true})
+	if err != nil || len(removed) != 1 || removed[0] != anonymous.Name {
+		t.Errorf("prune = %v, %v", removed, err)
+	}
+	if reclaimed != 0 {
+		t.Errorf("empty volume reclaimed %d bytes", reclaimed)
+	}
+	if _, reclaimed, _ := Prune(nil); reclaimed != int64(len("rows")) {
+		t.Errorf("reclaimed %d bytes", reclaimed)
+	}
+	if _, err := Get("data"); err == nil {
+		t.Errorf("pruned volume still exists")
+	}
+}
+
+func TestIsNamed(t *testing.T) {
+	cases := map[string]bool{
+		"data":      true,
+		"/srv/data": false,
+		"./data":    false,
+		"":          false,
+	}
+	for source, expected := range cases {
+		if IsNamed(source) != expected {
+			t.Errorf("IsNamed(%q) = %v", source, !expected)
+		}
+	}
+}
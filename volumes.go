@@ -0,0 +1,180 @@
+package main
+
+import (
+	"./container"
+	"./volume"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Parse the -v, --mount and --tmpfs flags of run. Volume mounts get the directory
+// of their named volume, which is created on first use, or of a new
+// anonymous volume when no name is given.
+func parseMounts(volumeSpecs, mountSpecs, tmpfsSpecs []string) ([]container.Mount, error) {
+	var mounts []container.Mount
+	for _, spec := range volumeSpecs {
+		m, err := container.ParseVolumeFlag(spec)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, *m)
+	}
+	for _, spec := range mountSpecs {
+		m, err := container.ParseMountFlag(spec)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, *m)
+	}
+	for _, spec := range tmpfsSpecs {
+		m, err := container.ParseTmpfsFlag(spec)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, *m)
+	}
+
+	destinations := map[string]bool{}
+	for i := range mounts {
+		m := &mounts[i]
+		if destinations[m.Destination] {
+			return nil, fmt.Errorf("Duplicate mount point: %s", m.Destination)
+		}
+		destinations[m.Destination] = true
+		if m.Type != container.MountTypeVolume {
+			continue
+		}
+		v, err := volume.Get(m.Source)
+		if err != nil {
+			if v, err = volume.Create(m.Source, "", nil); err != nil {
+				return nil, err
+			}
+		}
+		m.Source, m.Path = v.Name, v.Mountpoint
+	}
+	// Mount parents before the mounts nested in them
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return strings.Count(mounts[i].Destination, "/") < strings.Count(mounts[j].Destination, "/")
+	})
+	return mounts, nil
+}
+
+// Return named volumes in use, mapping to the containers using them
+func volumesInUse() (map[string][]string, error) {
+	containers, err := listContainerInfos()
+	if err != nil {
+		return nil, err
+	}
+	users := map[string][]string{}
+	for _, info := range containers {
+		for _, m := range info.Mounts {
+			if m.Type == container.MountTypeVolume {
+				users[m.Source] = append(users[m.Source], info.Name)
+			}
+		}
+	}
+	return users, nil
+}
+
+func createVolume(name, driver string, labelSlice []string) error {
+	v, err := volume.Create(name, driver, parseLabels(labelSlice))
+	if err != nil {
+		return err
+	}
+	fmt.Println(v.Name)
+	return nil
+}
+
+func listVolumes() error {
+	volumes, err := volume.List()
+	if err != nil {
+		return err
+	}
+	users, err := volumesInUse()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "DRIVER\tVOLUME NAME\tCREATED\tCONTAINERS\n")
+	for _, v := range volumes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n",
+			v.Driver,
+			v.Name,
+			formatCreated(v.CreatedAt),
+			len(users[v.Name]))
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+		return err
+	}
+	return nil
+}
+
+func inspectVolumes(names []string) error {
+	users, err := volumesInUse()
+	if err != nil {
+		return err
+	}
+	var volumes []*volume.Volume
+	for _, name := range names {
+		v, err := volume.Get(name)
+		if err != nil {
+			return err
+		}
+		v.UsedBy = users[name]
+		volumes = append(volumes, v)
+	}
+	content, err := json.MarshalIndent(volumes, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(content))
+	return nil
+}
+
+// Remove volumes, a volume used by a container, even a stopped one, is
+// refused
+func removeVolumes(names []string) error {
+	users, err := volumesInUse()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if len(users[name]) > 0 {
+			return fmt.Errorf("Volume %s is being used by container %s", name, strings.Join(users[name], ", "))
+		}
+		if err := volume.Remove(name); err != nil {
+			return err
+		}
+		fmt.Println(name)
+	}
+	return nil
+}
+
+func pruneVolumes() error {
+	users, err := volumesInUse()
+	if err != nil {
+		return err
+	}
+	inUse := map[string]bool{}
+	for name := range users {
+		inUse[name] = true
+	}
+	removed, reclaimed, err := volume.Prune(inUse)
+	if len(removed) > 0 {
+		fmt.Println("Deleted Volumes:")
+	}
+	for _, name := range removed {
+		fmt.Println(name)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Total reclaimed space: %s\n", humanSize(reclaimed))
+	return nil
+}